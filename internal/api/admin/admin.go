@@ -0,0 +1,66 @@
+// package admin specifies endpoints for operating on topics and their commit
+// logs rather than on records.
+package admin
+
+import (
+	"net/http"
+
+	"github.com/beautifultovarisch/dlog/internal/server"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+)
+
+// Request is empty, as admin operations take no input.
+type Request struct{}
+
+// RotateResponse contains the ID of the key made current by a rotation.
+type RotateResponse struct {
+	KeyID uint32 `json:"keyId"`
+}
+
+// POST /admin/keys/rotate
+//
+// Rotate returns a handler which adds a new key to [k] and makes it current.
+// Segments created afterwards are encrypted under it, while existing segments
+// keep their keys until re-encrypted.
+func Rotate(k keyring.Rotator) server.Handler[Request, RotateResponse] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*RotateResponse, error) {
+		id, err := k.Rotate()
+		if err != nil {
+			return nil, err
+		}
+
+		res := RotateResponse{id}
+
+		return &res, nil
+	}
+}
+
+// ReencryptResponse contains the number of segments rewritten under the
+// current key.
+type ReencryptResponse struct {
+	Segments int `json:"segments"`
+}
+
+// POST /admin/reencrypt
+//
+// Reencrypt returns a handler which rewrites the sealed segments of each of
+// [logs] not yet encrypted under the current key.
+func Reencrypt(logs ...storage.Reencrypter) server.Handler[Request, ReencryptResponse] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*ReencryptResponse, error) {
+		var total int
+		for _, l := range logs {
+			n, err := l.Reencrypt()
+			if err != nil {
+				return nil, err
+			}
+
+			total += n
+		}
+
+		res := ReencryptResponse{total}
+
+		return &res, nil
+	}
+}
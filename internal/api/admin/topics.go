@@ -0,0 +1,126 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/beautifultovarisch/dlog/internal/group"
+	"github.com/beautifultovarisch/dlog/internal/server"
+	"github.com/beautifultovarisch/dlog/internal/topic"
+)
+
+// TopicRequest names a topic to create along with its configuration, which is
+// optional.
+type TopicRequest struct {
+	Name   string       `json:"name"`
+	Config topic.Config `json:"config"`
+}
+
+// TopicResponse describes a topic.
+type TopicResponse struct {
+	Name   string       `json:"name"`
+	Config topic.Config `json:"config"`
+}
+
+// TopicsResponse lists every topic.
+type TopicsResponse struct {
+	Topics []TopicResponse `json:"topics"`
+}
+
+// POST /admin/topics
+//
+// CreateTopic returns a handler which creates the topic described by a
+// [TopicRequest] in [reg].
+func CreateTopic(reg *topic.Registry) server.Handler[TopicRequest, TopicResponse] {
+	return func(req TopicRequest, w http.ResponseWriter, r *http.Request) (*TopicResponse, error) {
+		t, err := reg.Create(req.Name, req.Config)
+		if err != nil {
+			switch {
+			case errors.Is(err, topic.ErrExists):
+				w.WriteHeader(http.StatusConflict)
+			case errors.Is(err, topic.ErrInvalidName), errors.Is(err, topic.ErrInvalidConfig):
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			return nil, err
+		}
+
+		w.WriteHeader(http.StatusCreated)
+
+		res := TopicResponse{t.Name, t.Config()}
+
+		return &res, nil
+	}
+}
+
+// GET /admin/topics
+//
+// ListTopics returns a handler which lists the topics in [reg] by name.
+func ListTopics(reg *topic.Registry) server.Handler[Request, TopicsResponse] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*TopicsResponse, error) {
+		res := TopicsResponse{Topics: []TopicResponse{}}
+		for _, t := range reg.Topics() {
+			res.Topics = append(res.Topics, TopicResponse{t.Name, t.Config()})
+		}
+
+		return &res, nil
+	}
+}
+
+// PartitionsRequest contains the number of partitions to which a topic is
+// increased.
+type PartitionsRequest struct {
+	Partitions int `json:"partitions"`
+}
+
+// POST /admin/topics/{topic}/partitions
+//
+// IncreasePartitions returns a handler which increases the number of
+// partitions of [topic] in [reg] to that given by a [PartitionsRequest].
+// Existing records stay in their partitions.
+func IncreasePartitions(reg *topic.Registry) server.Handler[PartitionsRequest, TopicResponse] {
+	return func(req PartitionsRequest, w http.ResponseWriter, r *http.Request) (*TopicResponse, error) {
+		t, err := reg.IncreasePartitions(r.PathValue("topic"), req.Partitions)
+		if err != nil {
+			switch {
+			case errors.Is(err, topic.ErrNotFound):
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, topic.ErrInvalidConfig):
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			return nil, err
+		}
+
+		res := TopicResponse{t.Name, t.Config()}
+
+		return &res, nil
+	}
+}
+
+// DELETE /admin/topics/{topic}
+//
+// DeleteTopic returns a handler which deletes [topic] from [reg] along with
+// its records. The offsets committed for it in [g] are removed first, so they
+// are never left behind for a topic recreated under the same name. Any
+// committed concurrently are removed by [group.Store.Prune] on restart.
+func DeleteTopic(reg *topic.Registry, g *group.Store) server.Handler[Request, Request] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*Request, error) {
+		name := r.PathValue("topic")
+		if err := g.DeleteTopic(name); err != nil {
+			return nil, err
+		}
+
+		if err := reg.Delete(name); err != nil {
+			if errors.Is(err, topic.ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+			}
+
+			return nil, err
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil, nil
+	}
+}
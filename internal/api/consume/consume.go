@@ -8,7 +8,12 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/beautifultovarisch/dlog/internal/group"
+	"github.com/beautifultovarisch/dlog/internal/server"
+
 	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
 )
 
 // Request contains information for requesting a particular record based on an
@@ -22,28 +27,97 @@ type Response struct {
 	Record record.Record `json:"record"`
 }
 
-// GET /consume/{offset}
+// GET /topics/{topic}/partitions/{partition}/consume/{offset}
+// GET /topics/{topic}/consume/{offset}
 //
-// Consume returns the record specified by [offset] or an error if not found.
-func Consume(req Request, w http.ResponseWriter, r *http.Request) (*Response, error) {
-	offset, err := strconv.ParseUint(r.PathValue("offset"), 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid offset: %v", offset)
-	}
+// Consume returns a handler which reads the record specified by [offset] from
+// [l] or an error if not found.
+func Consume(l storage.Log) server.Handler[Request, Response] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*Response, error) {
+		offset, err := strconv.ParseUint(r.PathValue("offset"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, fmt.Errorf("invalid offset: %s", r.PathValue("offset"))
+		}
+
+		w.Header().Set("x-trace-id", "123")
 
-	w.Header().Set("x-trace-id", "123")
+		rec, err := l.Read(offset)
+		if err != nil {
+			writeStatus(w, err)
 
-	rec, err := record.Read(offset)
-	if err != nil {
-		var notFound record.RecordNotFound
-		if errors.As(err, &notFound) {
-			w.WriteHeader(http.StatusNotFound)
+			return nil, err
 		}
 
-		return nil, err
+		res := Response{*rec}
+
+		return &res, nil
+	}
+}
+
+// GET /topics/{topic}/partitions/{partition}/consume?group={group}
+// GET /topics/{topic}/consume?group={group}
+//
+// Resume returns a function making handlers which read from [l] the first
+// record at or after the offset committed in [g] by [group] for the partition,
+// or the first record of the partition if the group has committed nothing.
+// Consuming does not commit, so the group only moves on once the consumer
+// commits the offset after that of the record.
+func Resume(g *group.Store) func(l storage.Log) server.Handler[Request, Response] {
+	return func(l storage.Log) server.Handler[Request, Response] {
+		return func(req Request, w http.ResponseWriter, r *http.Request) (*Response, error) {
+			name := r.URL.Query().Get("group")
+			if name == "" {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return nil, errors.New("missing group")
+			}
+
+			// The partition has already been resolved by the topic, and is 0
+			// if the route does not name one.
+			partition, _ := strconv.Atoi(r.PathValue("partition"))
+
+			offset, ok := g.Committed(name, r.PathValue("topic"), partition)
+			if !ok {
+				offset = l.LowestOffset()
+			}
+
+			var rec *record.Record
+			err := l.Range(offset, func(next *record.Record) bool {
+				rec = next
+
+				return false
+			})
+
+			if err == nil && rec == nil {
+				err = fmt.Errorf("%w: no record at or after %d", storage.ErrOutOfBounds, offset)
+			}
+
+			if err != nil {
+				writeStatus(w, err)
+
+				return nil, err
+			}
+
+			res := Response{*rec}
+
+			return &res, nil
+		}
 	}
+}
 
-	res := Response{rec}
+// writeStatus writes the status reporting [err], an error reading a record.
+func writeStatus(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrOutOfBounds) {
+		w.WriteHeader(http.StatusNotFound)
+	}
 
-	return &res, nil
+	// A corrupt record exists but cannot be served. Report this distinctly so
+	// clients do not mistake it for a missing offset.
+	var corrupt segment.ErrCorrupt
+	if errors.As(err, &corrupt) {
+		w.Header().Set("x-corrupt-segment", strconv.FormatUint(corrupt.BaseOffset, 10))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
@@ -0,0 +1,88 @@
+// package groups specifies the endpoints through which consumer groups commit
+// and look up their offsets.
+package groups
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/beautifultovarisch/dlog/internal/group"
+	"github.com/beautifultovarisch/dlog/internal/server"
+	"github.com/beautifultovarisch/dlog/internal/topic"
+)
+
+// Request is empty, as lookups are specified by the path.
+type Request struct{}
+
+// CommitRequest contains the offsets to be committed for a group.
+type CommitRequest struct {
+	Offsets []group.Offset `json:"offsets"`
+}
+
+// OffsetsResponse contains the offsets committed by a group.
+type OffsetsResponse struct {
+	Offsets []group.Offset `json:"offsets"`
+}
+
+// POST /groups/{group}/commit
+//
+// Commit returns a handler which commits the offsets of a [CommitRequest] for
+// [group] in [g] as a single unit. Every offset must belong to a partition of a
+// topic in [reg].
+func Commit(g *group.Store, reg *topic.Registry) server.Handler[CommitRequest, Request] {
+	return func(req CommitRequest, w http.ResponseWriter, r *http.Request) (*Request, error) {
+		if len(req.Offsets) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, errors.New("no offsets to commit")
+		}
+
+		for _, o := range req.Offsets {
+			if err := exists(reg, o.Topic, o.Partition); err != nil {
+				w.WriteHeader(http.StatusNotFound)
+
+				return nil, err
+			}
+		}
+
+		if err := g.Commit(r.PathValue("group"), req.Offsets); err != nil {
+			if errors.Is(err, group.ErrInvalidName) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			return nil, err
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil, nil
+	}
+}
+
+// exists returns an error if [reg] has no topic [name] with [partition].
+func exists(reg *topic.Registry, name string, partition int) error {
+	t, err := reg.Acquire(name)
+	if err != nil {
+		return err
+	}
+	defer t.Release()
+
+	if _, ok := t.Partition(partition); !ok {
+		return fmt.Errorf("topic %s has no partition %d", name, partition)
+	}
+
+	return nil
+}
+
+// GET /groups/{group}/offsets
+//
+// Offsets returns a handler which lists the offsets committed by [group] in
+// [g]. A group which has committed nothing has no offsets.
+func Offsets(g *group.Store) server.Handler[Request, OffsetsResponse] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*OffsetsResponse, error) {
+		res := OffsetsResponse{g.Offsets(r.PathValue("group"))}
+
+		return &res, nil
+	}
+}
@@ -0,0 +1,90 @@
+// package offsets defines lookups of offsets in the commit log by properties
+// of their records rather than by position.
+package offsets
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/server"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+)
+
+// Request is empty, as the lookup is specified by query parameters.
+type Request struct{}
+
+// Response contains the offset found by a lookup.
+type Response struct {
+	Offset uint64 `json:"offset"`
+}
+
+// parseTime accepts either milliseconds since the Unix epoch or an RFC 3339
+// formatted time.
+func parseTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// GET /topics/{topic}/partitions/{partition}/offsets?timestamp={timestamp}
+// GET /topics/{topic}/offsets?timestamp={timestamp}
+//
+// Offsets returns a handler which looks up the offset of the first record in
+// [l] whose timestamp is at or after [timestamp]. The timestamp is given in
+// milliseconds since the Unix epoch or in RFC 3339 format.
+func Offsets(l storage.TimeIndexed) server.Handler[Request, Response] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*Response, error) {
+		param := r.URL.Query().Get("timestamp")
+		if param == "" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, errors.New("missing timestamp")
+		}
+
+		t, err := parseTime(param)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, fmt.Errorf("invalid timestamp: %s", param)
+		}
+
+		off, err := l.OffsetForTime(t)
+		if err != nil {
+			if errors.Is(err, storage.ErrTimeOutOfBounds) {
+				w.WriteHeader(http.StatusNotFound)
+			}
+
+			return nil, err
+		}
+
+		res := Response{off}
+
+		return &res, nil
+	}
+}
+
+// BoundsResponse contains the range of offsets currently held by the log. The
+// lowest offset advances as old segments are deleted by retention.
+type BoundsResponse struct {
+	Lowest  uint64 `json:"lowest"`
+	Highest uint64 `json:"highest"`
+}
+
+// GET /topics/{topic}/partitions/{partition}/offsets/bounds
+// GET /topics/{topic}/offsets/bounds
+//
+// Bounds returns a handler which reports the lowest and highest offsets held
+// by [l].
+func Bounds(l storage.Log) server.Handler[Request, BoundsResponse] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*BoundsResponse, error) {
+		res := BoundsResponse{l.LowestOffset(), l.HighestOffset()}
+
+		return &res, nil
+	}
+}
@@ -0,0 +1,118 @@
+package produce
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/beautifultovarisch/dlog/internal/server"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+)
+
+// BatchRequest contains a list of records to be appended to a single partition
+// of the commit log as a single unit. If no [Partition] is named, the batch is
+// routed by the keys of its records, which must all route to the same
+// partition (see [partitionOf]).
+type BatchRequest struct {
+	Records    []record.Record `json:"records"`
+	Partition  *int            `json:"partition,omitempty"`
+	Durability string          `json:"durability,omitempty"`
+}
+
+// BatchResponse contains the partition along with the offsets of the first and
+// last records of a processed [BatchRequest]. The records occupy every offset
+// in between.
+type BatchResponse struct {
+	Partition  int    `json:"partition"`
+	BaseOffset uint64 `json:"baseOffset"`
+	LastOffset uint64 `json:"lastOffset"`
+}
+
+// POST /topics/{topic}/produce/batch
+//
+// ProduceBatch returns a handler which accepts a [BatchRequest] and appends
+// its records to a partition of [p] with contiguous offsets.
+func ProduceBatch(p storage.Partitioned) server.Handler[BatchRequest, BatchResponse] {
+	return func(req BatchRequest, w http.ResponseWriter, r *http.Request) (*BatchResponse, error) {
+		if err := validDurability(req.Durability); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, err
+		}
+
+		if len(req.Records) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, errors.New("empty batch")
+		}
+
+		if req.Partition == nil {
+			n, err := partitionOf(p, req.Records)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return nil, err
+			}
+
+			req.Partition = &n
+		}
+
+		partition, l, err := route(p, req.Partition, nil)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+
+			return nil, err
+		}
+
+		records := make([]*record.Record, len(req.Records))
+		for i := range req.Records {
+			records[i] = &req.Records[i]
+		}
+
+		base, err := l.AppendBatch(records)
+		if err != nil {
+			if errors.Is(err, storage.ErrBatchTooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+			}
+
+			return nil, err
+		}
+
+		if err := commit(l, req.Durability); err != nil {
+			return nil, err
+		}
+
+		res := BatchResponse{partition, base, base + uint64(len(records)) - 1}
+
+		return &res, nil
+	}
+}
+
+// partitionOf returns the partition to which every record in [records] is
+// routed by its key. Records without a key go along with the others, and a
+// batch without any keys is routed like a single record without a key. Since a
+// batch is appended to a single partition, an error is returned if the keys
+// route to different partitions.
+func partitionOf(p storage.Partitioned, records []record.Record) (int, error) {
+	n := -1
+	for _, rec := range records {
+		if len(rec.Key) == 0 {
+			continue
+		}
+
+		k := p.PartitionFor(rec.Key)
+		if n >= 0 && k != n {
+			return 0, fmt.Errorf("keys of batch route to partitions %d and %d", n, k)
+		}
+
+		n = k
+	}
+
+	if n < 0 {
+		return p.PartitionFor(nil), nil
+	}
+
+	return n, nil
+}
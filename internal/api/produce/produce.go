@@ -1,31 +1,106 @@
-// package produce specifies the POST /produce endpoint
+// package produce specifies the POST /topics/{topic}/produce and
+// POST /topics/{topic}/produce/batch endpoints
 package produce
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/beautifultovarisch/dlog/internal/server"
+
 	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
 )
 
-// Request contains a [Record] to be appended to the commit log.
+// DurabilityFsync requests that a record be committed to stable storage before
+// its offset is returned, regardless of the log's sync policy.
+const DurabilityFsync = "fsync"
+
+// Request contains a [Record] to be appended to the commit log. [Partition]
+// is optional, and the record is routed by its key if none is named.
+// [Durability] is optional and defaults to the durability policy of the log.
 type Request struct {
-	Record record.Record `json:"record"`
+	Record     record.Record `json:"record"`
+	Partition  *int          `json:"partition,omitempty"`
+	Durability string        `json:"durability,omitempty"`
 }
 
-// Response contains the offset of a processed [Record] contained in a [Request]
+// Response contains the partition and offset of a processed [Record] contained
+// in a [Request]
 type Response struct {
-	Offset uint64 `json:"offset"`
+	Partition int    `json:"partition"`
+	Offset    uint64 `json:"offset"`
 }
 
-// Produce accepts a [Request] containing a record and appends it to the commit
-// log. A [Response] containing the offset of the response is returned.
-func Produce(req Request, w http.ResponseWriter, r *http.Request) (*Response, error) {
-	offset, err := record.Append(req.Record)
-	if err != nil {
-		return nil, err
+// POST /topics/{topic}/produce
+//
+// Produce returns a handler which accepts a [Request] containing a record and
+// appends it to a partition of [p]. A [Response] containing the partition and
+// offset of the record is returned.
+func Produce(p storage.Partitioned) server.Handler[Request, Response] {
+	return func(req Request, w http.ResponseWriter, r *http.Request) (*Response, error) {
+		if err := validDurability(req.Durability); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return nil, err
+		}
+
+		partition, l, err := route(p, req.Partition, req.Record.Key)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+
+			return nil, err
+		}
+
+		offset, err := l.Append(&req.Record)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := commit(l, req.Durability); err != nil {
+			return nil, err
+		}
+
+		res := Response{partition, offset}
+
+		return &res, nil
 	}
+}
 
-	res := Response{offset}
+// route returns the partition of [p] named by [partition], or that to which
+// [key] is routed if none is named, along with its log.
+func route(p storage.Partitioned, partition *int, key []byte) (int, storage.Log, error) {
+	var n int
+	if partition != nil {
+		n = *partition
+	} else {
+		n = p.PartitionFor(key)
+	}
+
+	l, ok := p.Partition(n)
+	if !ok {
+		return n, nil, fmt.Errorf("no partition %d", n)
+	}
+
+	return n, l, nil
+}
+
+func validDurability(durability string) error {
+	if durability != "" && durability != DurabilityFsync {
+		return fmt.Errorf("invalid durability: %s", durability)
+	}
+
+	return nil
+}
+
+// commit syncs [l] if requested by [durability]. Records can only be appended
+// to the active segment, and any segment sealed since the append has already
+// been synced. Syncing the log is therefore sufficient to make the appended
+// records durable. A log which cannot be synced has nothing to commit.
+func commit(l storage.Log, durability string) error {
+	if s, ok := l.(storage.Syncer); ok && durability == DurabilityFsync {
+		return s.Sync()
+	}
 
-	return &res, nil
+	return nil
 }
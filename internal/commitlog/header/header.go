@@ -0,0 +1,216 @@
+// package header describes the fixed header at the start of every file backing
+// a segment. The header identifies the kind of file and the format it was
+// written in, so files from different versions may coexist in a log directory
+// and files which do not belong there are rejected.
+package header
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Magic identifies the kind of file a header begins.
+type Magic [4]byte
+
+var (
+	Store     = Magic{'D', 'L', 'S', 'T'} // Store begins a store file.
+	Index     = Magic{'D', 'L', 'I', 'X'} // Index begins an offset index file.
+	TimeIndex = Magic{'D', 'L', 'T', 'I'} // TimeIndex begins a time index file.
+)
+
+const (
+	// Version is the version of the format written by this package. Version 2
+	// added the key ID.
+	Version = 2
+
+	// Width is the number of bytes occupied by a header. Headers are padded to
+	// leave room for future fields.
+	Width = 32
+)
+
+var (
+	enc = binary.BigEndian
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrMagic is returned when a file does not begin with the expected magic
+	// bytes, i.e. it is not the expected kind of file or was not written by
+	// dlog at all.
+	ErrMagic = errors.New("bad magic bytes")
+
+	// ErrVersion is returned when a file was written in a newer format.
+	ErrVersion = errors.New("unsupported format version")
+
+	// ErrCorrupt is returned when a header fails checksum verification.
+	ErrCorrupt = errors.New("header checksum mismatch")
+)
+
+// Header is the metadata written at the start of a file:
+//
+//	0      4         6      7        8       16        24    28    32
+//	[magic][version][codec][unused][base  ][created ][key ][crc ]
+//
+// [crc] is the CRC32C checksum of the preceding bytes. Version 1 headers have
+// no key, and their checksum occupies its place instead.
+type Header struct {
+	Magic      Magic
+	Version    uint16
+	Codec      uint8     // Codec is the compression configured when the file was created.
+	BaseOffset uint64    // BaseOffset is the base offset of the segment.
+	Created    time.Time // Created is when the file was created, in milliseconds.
+	KeyID      uint32    // KeyID identifies the key encrypting the file, or is 0 if it is unencrypted.
+}
+
+// crcPos returns the position of the checksum in a header of [version].
+func crcPos(version uint16) int {
+	if version < 2 {
+		return 24
+	}
+
+	return 28
+}
+
+// Encode returns the binary representation of [h] in the format of its
+// version.
+func (h Header) Encode() []byte {
+	b := make([]byte, Width)
+
+	copy(b, h.Magic[:])
+	enc.PutUint16(b[4:], h.Version)
+	b[6] = h.Codec
+	enc.PutUint64(b[8:], h.BaseOffset)
+	enc.PutUint64(b[16:], uint64(h.Created.UnixMilli()))
+
+	pos := crcPos(h.Version)
+	if pos > 24 {
+		enc.PutUint32(b[24:], h.KeyID)
+	}
+
+	enc.PutUint32(b[pos:], crc32.Checksum(b[:pos], crcTable))
+
+	return b
+}
+
+// Decode parses the header in [b], which must be at least [Width] bytes long,
+// and verifies that it begins with [magic].
+func Decode(b []byte, magic Magic) (Header, error) {
+	if len(b) < Width {
+		return Header{}, io.ErrUnexpectedEOF
+	}
+
+	h := Header{
+		Magic:      Magic(b[:4]),
+		Version:    enc.Uint16(b[4:]),
+		Codec:      b[6],
+		BaseOffset: enc.Uint64(b[8:]),
+		Created:    time.UnixMilli(int64(enc.Uint64(b[16:]))),
+	}
+
+	if h.Magic != magic {
+		return Header{}, ErrMagic
+	}
+
+	pos := crcPos(h.Version)
+	if enc.Uint32(b[pos:]) != crc32.Checksum(b[:pos], crcTable) {
+		return Header{}, ErrCorrupt
+	}
+
+	if pos > 24 {
+		h.KeyID = enc.Uint32(b[24:])
+	}
+
+	if h.Version > Version {
+		return Header{}, fmt.Errorf("%w: %d", ErrVersion, h.Version)
+	}
+
+	return h, nil
+}
+
+// Read reads and decodes the header at the start of [r]. [ErrMagic] is returned
+// if [r] does not begin with [magic], even if it is shorter than a header.
+func Read(r io.ReaderAt, magic Magic) (Header, error) {
+	b := make([]byte, Width)
+
+	n, err := r.ReadAt(b, 0)
+	if n < len(magic) || Magic(b[:len(magic)]) != magic {
+		return Header{}, ErrMagic
+	}
+
+	if n < Width {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return Header{}, err
+	}
+
+	return Decode(b, magic)
+}
+
+// Init ensures [f] begins with a header of the same kind and segment as [h]. A
+// new, empty file is given the header [h], as is a file holding only part of
+// such a header, which was torn while the file was created. Otherwise, the
+// file's header is read and verified, and returned in place of [h].
+func Init(f *os.File, h Header) (Header, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return Header{}, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+
+	torn, err := isTorn(f, stat.Size(), h.Magic)
+	if err != nil {
+		return Header{}, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+
+	if stat.Size() == 0 || torn {
+		if err := f.Truncate(0); err != nil {
+			return Header{}, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+
+		// Files may be opened for appending, in which case writes ignore the
+		// offset, so the header is written after seeking to the start.
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return Header{}, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+
+		if _, err := f.Write(h.Encode()); err != nil {
+			return Header{}, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+
+		return h, nil
+	}
+
+	existing, err := Read(f, h.Magic)
+	if err != nil {
+		return Header{}, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+
+	if existing.BaseOffset != h.BaseOffset {
+		return Header{}, fmt.Errorf("%s: header has base offset %d, expected %d", f.Name(), existing.BaseOffset, h.BaseOffset)
+	}
+
+	return existing, nil
+}
+
+// isTorn reports whether [f], which is [size] bytes long, holds nothing but the
+// beginning of a header with [magic]. No data is written to a file before its
+// header is complete, so nothing follows a torn header.
+func isTorn(f *os.File, size int64, magic Magic) (bool, error) {
+	if size == 0 || size >= Width {
+		return false, nil
+	}
+
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, 0); err != nil {
+		return false, err
+	}
+
+	n := min(len(b), len(magic))
+
+	return string(b[:n]) == string(magic[:n]), nil
+}
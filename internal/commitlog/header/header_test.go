@@ -0,0 +1,123 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	h := Header{
+		Magic:      Store,
+		Version:    Version,
+		Codec:      2,
+		BaseOffset: 1 << 40,
+		Created:    time.UnixMilli(1_700_000_000_000),
+		KeyID:      7,
+	}
+
+	t.Run("Encode", func(t *testing.T) {
+		b := h.Encode()
+		if len(b) != Width {
+			t.Fatalf("expected header of %d bytes. Got %d", Width, len(b))
+		}
+
+		got, err := Decode(b, Store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != h {
+			t.Errorf("expected %+v. Got %+v", h, got)
+		}
+	})
+
+	t.Run("Decode", func(t *testing.T) {
+		if _, err := Decode(h.Encode(), Index); err != ErrMagic {
+			t.Errorf("expected %v. Got %v", ErrMagic, err)
+		}
+
+		b := h.Encode()
+		b[10] ^= 1
+		if _, err := Decode(b, Store); err != ErrCorrupt {
+			t.Errorf("expected %v. Got %v", ErrCorrupt, err)
+		}
+
+		// The key is covered by the checksum.
+		b = h.Encode()
+		b[25] ^= 1
+		if _, err := Decode(b, Store); err != ErrCorrupt {
+			t.Errorf("expected %v. Got %v", ErrCorrupt, err)
+		}
+
+		// Version 1 headers remain readable.
+		v1 := h
+		v1.Version, v1.KeyID = 1, 0
+		if got, err := Decode(v1.Encode(), Store); err != nil || got != v1 {
+			t.Errorf("expected %+v. Got %+v (%v)", v1, got, err)
+		}
+
+		newer := h
+		newer.Version = Version + 1
+		if _, err := Decode(newer.Encode(), Store); !errors.Is(err, ErrVersion) {
+			t.Errorf("expected %v. Got %v", ErrVersion, err)
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		// Files shorter than a header are still recognized as foreign.
+		if _, err := Read(bytes.NewReader([]byte("no")), Store); err != ErrMagic {
+			t.Errorf("expected %v. Got %v", ErrMagic, err)
+		}
+	})
+
+	t.Run("Init", func(t *testing.T) {
+		tmp, err := os.CreateTemp("", "test_header")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		})
+
+		if _, err := Init(tmp, h); err != nil {
+			t.Fatal(err)
+		}
+
+		// The header already in the file takes precedence.
+		later := h
+		later.Created = h.Created.Add(time.Hour)
+
+		got, err := Init(tmp, later)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != h {
+			t.Errorf("expected %+v. Got %+v", h, got)
+		}
+
+		other := h
+		other.BaseOffset++
+		if _, err := Init(tmp, other); err == nil {
+			t.Error("expected error for mismatched base offset")
+		}
+
+		// A header torn while the file was created is written again.
+		if err := tmp.Truncate(Width / 2); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := Init(tmp, later); err != nil || got != later {
+			t.Errorf("expected %+v. Got %+v (%v)", later, got, err)
+		}
+
+		if got, err := Read(tmp, Store); err != nil || got != later {
+			t.Errorf("expected %+v to be written. Got %+v (%v)", later, got, err)
+		}
+	})
+}
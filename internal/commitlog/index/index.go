@@ -6,9 +6,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
 	"os"
+	"sync/atomic"
 
 	"golang.org/x/sys/unix"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
 )
 
 var (
@@ -18,32 +22,57 @@ var (
 // ErrEmptyFile is returned when attempting to back an index with an empty file
 var ErrEmptyFile = errors.New("index cannot be backed by empty file")
 
+// ErrOffsetOverflow is returned when writing an entry whose offset exceeds
+// [MaxOffset]. A segment whose next relative offset overflows must be rolled.
+var ErrOffsetOverflow = errors.New("relative offset overflows index entry")
+
 const (
 	posWidth    = 8                      // The width of the section containing the position of a record
 	offsetWidth = 4                      // The width of the section containing the offset of a record
 	recordWidth = posWidth + offsetWidth // The total width of an index entry
+
+	// MaxOffset is the greatest offset which fits in an entry.
+	MaxOffset = math.MaxUint32
 )
 
 // Index encapulates the association between records and their offset on disk.
+//
+// An index has a single writer, but may be read concurrently. Entries are
+// written before the size is advanced, so readers only ever observe complete
+// entries.
 type Index struct {
-	*os.File        // The file backing the index
-	buf      []byte // The region in memory onto which [File] is mapped.
-	size     uint64 // The size of the backing file.
+	*os.File               // The file backing the index
+	header   header.Header // The header at the start of [File].
+	mmap     []byte        // The region in memory onto which [File] is mapped.
+	buf      []byte        // The entries following the header in [mmap].
+	size     atomic.Uint64 // The size of the entries in the backing file.
 }
 
 // New creates a new index against [f] upper bounded by [maxBytes]. The file at
-// [f] is truncated to [maxBytes].
-func New(f *os.File, maxBytes uint64) (*Index, error) {
+// [f] begins with the header [h] (see [header.Init]), and is truncated to hold
+// the header and [maxBytes] of entries. The magic bytes of [h] distinguish the
+// kind of index.
+//
+// If [f] already contains entries, the size of the index is the length of the
+// longest prefix of well-formed entries rather than the size of the file. This
+// discards the zeroed tail left behind when the process dies before [Close]
+// truncates the file.
+func New(f *os.File, maxBytes uint64, h header.Header) (*Index, error) {
 	if maxBytes == 0 {
 		return nil, ErrEmptyFile
 	}
 
+	h, err := header.Init(f, h)
+	if err != nil {
+		return nil, err
+	}
+
 	stat, err := os.Stat(f.Name())
 	if err != nil {
 		return nil, err
 	}
 
-	if err := os.Truncate(f.Name(), int64(maxBytes)); err != nil {
+	if err := os.Truncate(f.Name(), int64(header.Width+maxBytes)); err != nil {
 		return nil, err
 	}
 
@@ -51,62 +80,101 @@ func New(f *os.File, maxBytes uint64) (*Index, error) {
 	flags := unix.MAP_SHARED
 
 	// Map [f] as a shared region. This serves as the storage for the index.
-	b, err := unix.Mmap(int(f.Fd()), 0, int(maxBytes), prot, flags)
+	b, err := unix.Mmap(int(f.Fd()), 0, int(header.Width+maxBytes), prot, flags)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Index{
-		File: f,
-		buf:  b,
-		size: uint64(stat.Size()),
-	}, nil
+	size := min(uint64(stat.Size()-header.Width), maxBytes)
+
+	i := &Index{
+		File:   f,
+		header: h,
+		mmap:   b,
+		buf:    b[header.Width:],
+	}
+	i.size.Store(validSize(i.buf[:size]))
+
+	return i, nil
+}
+
+// validSize returns the length in bytes of the longest prefix of [buf] whose
+// entries have strictly increasing offsets and non-decreasing positions. Many
+// offsets may share a position, but entries are always written in this order,
+// so anything following a violation is garbage.
+func validSize(buf []byte) uint64 {
+	n := uint64(len(buf)) / recordWidth
+	if n == 0 {
+		return 0
+	}
+
+	// The first entry is trivially ordered.
+	k := uint64(1)
+	for ; k < n; k++ {
+		cur, prev := k*recordWidth, (k-1)*recordWidth
+
+		off, prevOff := enc.Uint32(buf[cur:]), enc.Uint32(buf[prev:])
+		pos, prevPos := enc.Uint64(buf[cur+offsetWidth:]), enc.Uint64(buf[prev+offsetWidth:])
+
+		if off <= prevOff || pos < prevPos {
+			break
+		}
+	}
+
+	return k * recordWidth
 }
 
 // Close persists the memory-mapped file to stable storage and truncates the
 // memory region to the actual size of the index.
 func (i *Index) Close() error {
 	// Flush contents of the buffer and file before unmapping
-	if err := unix.Msync(i.buf, unix.MS_SYNC); err != nil {
-		return nil
-	}
-
-	if err := i.File.Sync(); err != nil {
+	if err := i.Sync(); err != nil {
 		return err
 	}
 
 	// Truncate the file to the measured size of the index.
-	if err := i.File.Truncate(int64(i.size)); err != nil {
+	if err := i.File.Truncate(int64(header.Width + i.size.Load())); err != nil {
 		return err
 	}
 
 	// TODO: Determine if this call to unmap is needed/intended.
-	if err := unix.Munmap(i.buf); err != nil {
+	if err := unix.Munmap(i.mmap); err != nil {
 		return err
 	}
 
 	return i.File.Close()
 }
 
+// Sync commits the memory-mapped region and the backing file to stable
+// storage.
+func (i *Index) Sync() error {
+	if err := unix.Msync(i.mmap, unix.MS_SYNC); err != nil {
+		return err
+	}
+
+	return i.File.Sync()
+}
+
 // Read accepts an offset and computes the corresponding record's position in
 // the store. Providing [in=-1] will read the record from the end of the index.
 //
 // NOTE: Unlike indexing into Python lists, values other than -1 will return an
 // error rather than correspond to the [n+i]th element.
 func (i *Index) Read(in int64) (uint32, uint64, error) {
-	if i.size == 0 {
+	size := i.size.Load()
+	if size == 0 {
 		return 0, 0, io.EOF
 	}
 
 	var off uint32 = uint32(in)
 	if in == -1 {
 		// This computes the offset of the last record in the index.
-		off = uint32((i.size / recordWidth) - 1)
+		off = uint32((size / recordWidth) - 1)
 	}
 
 	// Compute the absolute position of the record.
 	pos := uint64(off) * recordWidth
-	if i.size < pos+recordWidth {
+	if size < pos+recordWidth {
 		return 0, 0, io.EOF
 	}
 
@@ -119,20 +187,56 @@ func (i *Index) Read(in int64) (uint32, uint64, error) {
 }
 
 // Write stores the position of the record at [off] in the index. If the record
-// would not fit in the index, [io.EOF] is returned.
-func (i *Index) Write(off uint32, pos uint64) error {
-	if uint64(len(i.buf)) < i.size+recordWidth {
+// would not fit in the index, [io.EOF] is returned. Offsets are stored in 32
+// bits, so [ErrOffsetOverflow] is returned if [off] exceeds [MaxOffset].
+func (i *Index) Write(off uint64, pos uint64) error {
+	if off > MaxOffset {
+		return ErrOffsetOverflow
+	}
+
+	size := i.size.Load()
+	if uint64(len(i.buf)) < size+recordWidth {
 		return io.EOF
 	}
 
-	enc.PutUint32(i.buf[i.size:i.size+offsetWidth], off)
-	enc.PutUint64(i.buf[i.size+offsetWidth:i.size+recordWidth], pos)
+	enc.PutUint32(i.buf[size:size+offsetWidth], uint32(off))
+	enc.PutUint64(i.buf[size+offsetWidth:size+recordWidth], pos)
 
-	i.size += uint64(recordWidth)
+	i.size.Store(size + recordWidth)
 
 	return nil
 }
 
+// Truncate discards every entry in the index following the first [n]. The
+// discarded region is zeroed so stale entries cannot resurface after a crash.
+func (i *Index) Truncate(n uint64) error {
+	size := n * recordWidth
+	if size > i.size.Load() {
+		return io.EOF
+	}
+
+	clear(i.buf[size:i.size.Load()])
+	i.size.Store(size)
+
+	return nil
+}
+
+// Available returns the number of entries which may still be written before
+// the index is full.
+func (i *Index) Available() uint64 {
+	return (uint64(len(i.buf)) - i.size.Load()) / recordWidth
+}
+
+// Entries returns the number of entries in the index.
+func (i *Index) Entries() uint64 {
+	return i.size.Load() / recordWidth
+}
+
+// Header returns the header at the start of the file backing the index.
+func (i *Index) Header() header.Header {
+	return i.header
+}
+
 // Name returns the name of the memory-mapped file backing the index.
 func (i *Index) Name() string {
 	return i.File.Name()
@@ -140,5 +244,5 @@ func (i *Index) Name() string {
 
 // Size returns the current size of the index in bytes.
 func (i *Index) Size() uint64 {
-	return i.size
+	return i.size.Load()
 }
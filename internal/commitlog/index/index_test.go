@@ -1,12 +1,18 @@
 package index
 
 import (
+	"errors"
 	"io"
 	"os"
 	"testing"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
 )
 
-var maxBytes uint64 = (1 << 10)
+var (
+	maxBytes   uint64 = (1 << 10)
+	testHeader        = header.Header{Magic: header.Index, Version: header.Version}
+)
 
 func TestIndex(t *testing.T) {
 	run := func(name string, fn func(i *Index, t *testing.T)) {
@@ -16,7 +22,7 @@ func TestIndex(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			i, err := New(tmp, maxBytes)
+			i, err := New(tmp, maxBytes, testHeader)
 			if err != nil {
 				t.Fatalf("error creating index: %v", err)
 			}
@@ -41,7 +47,7 @@ func TestIndex(t *testing.T) {
 				os.Remove(tmp.Name())
 			})
 
-			i, err := New(tmp, 0)
+			i, err := New(tmp, 0, testHeader)
 			if err != ErrEmptyFile {
 				t.Errorf("expected New() to return EmptyFile given empty file")
 			}
@@ -61,7 +67,7 @@ func TestIndex(t *testing.T) {
 				os.Remove(tmp.Name())
 			})
 
-			i, err := New(tmp, maxBytes)
+			i, err := New(tmp, maxBytes, testHeader)
 			if err != nil {
 				t.Fatalf("error creating index: %v", err)
 			}
@@ -71,8 +77,31 @@ func TestIndex(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			if size := stat.Size(); uint64(size) != maxBytes {
-				t.Errorf("wrong index size. Expected: %d. Got: %d", maxBytes, size)
+			if size := stat.Size(); uint64(size) != header.Width+maxBytes {
+				t.Errorf("wrong index size. Expected: %d. Got: %d", header.Width+maxBytes, size)
+			}
+		})
+
+		t.Run("Foreign", func(t *testing.T) {
+			tmp, err := os.CreateTemp("", "index_foreign")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() {
+				tmp.Close()
+				os.Remove(tmp.Name())
+			})
+
+			// A time index is not an offset index.
+			h := testHeader
+			h.Magic = header.TimeIndex
+			if _, err := tmp.Write(h.Encode()); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := New(tmp, maxBytes, testHeader); !errors.Is(err, header.ErrMagic) {
+				t.Errorf("expected ErrMagic. Got: %v", err)
 			}
 		})
 	})
@@ -83,7 +112,7 @@ func TestIndex(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		i, err := New(tmp, maxBytes)
+		i, err := New(tmp, maxBytes, testHeader)
 		if err != nil {
 			t.Fatalf("error creating index: %v", err)
 		}
@@ -94,7 +123,12 @@ func TestIndex(t *testing.T) {
 		i.Write(1, 10)
 		i.Write(2, 20)
 
-		i, _ = New(tmp, maxBytes)
+		i, _ = New(tmp, maxBytes, testHeader)
+
+		// The zeroed remainder of the file must not be mistaken for entries.
+		if n := i.Entries(); n != 2 {
+			t.Errorf("expected %d entries. Got %d", 2, n)
+		}
 
 		for k := 0; k < 2; k++ {
 			o, p, _ := i.Read(int64(k))
@@ -120,7 +154,7 @@ func TestIndex(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		i, err := New(tmp, maxBytes)
+		i, err := New(tmp, maxBytes, testHeader)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -149,7 +183,7 @@ func TestIndex(t *testing.T) {
 		for k, test := range tests {
 			off, pos := test.o, test.p
 
-			err := i.Write(off, pos)
+			err := i.Write(uint64(off), pos)
 			if err != nil {
 				t.Errorf("error writing to (off=%d,pos=%d): %v", off, pos, err)
 			}
@@ -167,6 +201,10 @@ func TestIndex(t *testing.T) {
 			}
 		}
 
+		if err := i.Write(MaxOffset+1, 0); err != ErrOffsetOverflow {
+			t.Errorf("expected %v. Got %v", ErrOffsetOverflow, err)
+		}
+
 		// force EOF
 		i.buf = []byte{}
 		err := i.Write(0, 1)
@@ -177,12 +215,12 @@ func TestIndex(t *testing.T) {
 
 	run("Read", func(i *Index, t *testing.T) {
 		// Force EOF
-		old := i.size
-		i.size = 0
+		old := i.size.Load()
+		i.size.Store(0)
 		if _, _, err := i.Read(0); err != io.EOF {
 			t.Error("expected EOF on read from empty index")
 		}
-		i.size = old
+		i.size.Store(old)
 
 		tests := []struct {
 			o uint32
@@ -197,10 +235,11 @@ func TestIndex(t *testing.T) {
 		for idx, test := range tests {
 			off, pos := test.o, test.p
 
-			enc.PutUint32(i.buf[i.size:i.size+offsetWidth], off)
-			enc.PutUint64(i.buf[i.size+offsetWidth:i.size+recordWidth], pos)
+			size := i.size.Load()
+			enc.PutUint32(i.buf[size:size+offsetWidth], off)
+			enc.PutUint64(i.buf[size+offsetWidth:size+recordWidth], pos)
 
-			i.size += uint64(recordWidth)
+			i.size.Store(size + recordWidth)
 
 			actualOff, actualPos, err := i.Read(int64(idx))
 			if err != nil {
@@ -229,4 +268,28 @@ func TestIndex(t *testing.T) {
 			t.Errorf("expected position of %d. Got %d", expected, actualPos)
 		}
 	})
+
+	run("Truncate", func(i *Index, t *testing.T) {
+		for k := 0; k < 4; k++ {
+			if err := i.Write(uint64(k), uint64(k*10)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := i.Truncate(2); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := i.Entries(); n != 2 {
+			t.Errorf("expected %d entries. Got %d", 2, n)
+		}
+
+		if _, _, err := i.Read(2); err != io.EOF {
+			t.Errorf("expected EOF reading truncated entry. Got %v", err)
+		}
+
+		if err := i.Truncate(3); err != io.EOF {
+			t.Errorf("expected EOF truncating beyond the index. Got %v", err)
+		}
+	})
 }
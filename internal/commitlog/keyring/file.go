@@ -0,0 +1,199 @@
+package keyring
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// keySize is the size of the keys generated by [File.Rotate], selecting
+// AES-256.
+const keySize = 32
+
+// File is a [Keyring] kept in a file, one key per line as its ID followed by
+// its value in hexadecimal:
+//
+//	1 000102030405060708090a0b0c0d0e0f
+//	2 101112131415161718191a1b1c1d1e1f
+//
+// The key with the greatest ID is current. Blank lines and lines beginning with
+// '#' are ignored. Keys are secrets, so the file is only readable by its owner.
+type File struct {
+	mu      sync.RWMutex
+	path    string
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// OpenFile loads the keyring kept at [path]. If there is no such file, it is
+// created holding a single new key.
+func OpenFile(path string) (*File, error) {
+	f := File{path: path, keys: make(map[uint32][]byte)}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := f.Rotate(); err != nil {
+			return nil, err
+		}
+
+		return &f, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := f.parse(file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if f.current == 0 {
+		return nil, fmt.Errorf("%s: no keys", path)
+	}
+
+	return &f, nil
+}
+
+// parse adds the keys read from [r] to the keyring.
+func (f *File) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected an ID and a key", n)
+		}
+
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || id == 0 {
+			return fmt.Errorf("line %d: invalid key ID: %s", n, fields[0])
+		}
+
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return fmt.Errorf("line %d: invalid key size: %d", n, len(key))
+		}
+
+		if _, ok := f.keys[uint32(id)]; ok {
+			return fmt.Errorf("line %d: duplicate key ID: %d", n, id)
+		}
+
+		f.keys[uint32(id)] = key
+		f.current = max(f.current, uint32(id))
+	}
+
+	return scanner.Err()
+}
+
+// Current implements [Keyring].
+func (f *File) Current() (uint32, []byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.current, f.keys[f.current], nil
+}
+
+// Key implements [Keyring].
+func (f *File) Key(id uint32) ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	key, ok := f.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+// Rotate implements [Rotator]. The new key is random, and is persisted before
+// it becomes current.
+func (f *File) Rotate() (uint32, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+
+	id := f.current + 1
+	if id == 0 {
+		return 0, errors.New("key IDs exhausted")
+	}
+
+	keys := maps.Clone(f.keys)
+	keys[id] = key
+
+	if err := write(f.path, keys); err != nil {
+		return 0, err
+	}
+
+	f.keys, f.current = keys, id
+
+	return id, nil
+}
+
+// write replaces the file at [path] with one holding [keys]. The keys are
+// written to a temporary file which is then renamed into place, so a crash
+// never leaves a partial keyring behind.
+func write(path string, keys map[uint32][]byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	ids := make([]uint32, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	w := bufio.NewWriter(tmp)
+	for _, id := range ids {
+		fmt.Fprintf(w, "%d %x\n", id, keys[id])
+	}
+
+	// CreateTemp already restricts the file to its owner.
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
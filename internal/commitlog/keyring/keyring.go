@@ -0,0 +1,29 @@
+// package keyring supplies the keys with which segments are encrypted at rest.
+// Every key has an ID, which is recorded alongside the data it encrypts, so
+// data written under a retired key stays readable for as long as the keyring
+// still holds it.
+package keyring
+
+import "errors"
+
+// ErrUnknownKey is returned when a keyring does not hold the requested key.
+var ErrUnknownKey = errors.New("unknown key")
+
+// Keyring holds AES keys of 16, 24 or 32 bytes. Key IDs are never 0, which
+// marks unencrypted data.
+type Keyring interface {
+	// Current returns the ID and value of the key with which new data is
+	// encrypted.
+	Current() (uint32, []byte, error)
+
+	// Key returns the key [id], or an error wrapping [ErrUnknownKey] if there
+	// is none.
+	Key(id uint32) ([]byte, error)
+}
+
+// Rotator is implemented by keyrings which can generate their own keys.
+type Rotator interface {
+	// Rotate adds a new key and makes it current, returning its ID. Previous
+	// keys are kept so that data they encrypt remains readable.
+	Rotate() (uint32, error)
+}
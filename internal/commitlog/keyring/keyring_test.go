@@ -0,0 +1,75 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+
+	// A missing keyring is created with a single key.
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, first, err := f.Current()
+	if err != nil || id != 1 || len(first) != keySize {
+		t.Fatalf("expected key %d of %d bytes. Got key %d of %d bytes (%v)", 1, keySize, id, len(first), err)
+	}
+
+	if id, err := f.Rotate(); err != nil || id != 2 {
+		t.Fatalf("expected rotation to key %d. Got %d (%v)", 2, id, err)
+	}
+
+	// Rotated keys survive reopening, and older keys are kept.
+	if f, err = OpenFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _, _ := f.Current(); id != 2 {
+		t.Errorf("expected current key %d. Got %d", 2, id)
+	}
+
+	if key, err := f.Key(1); err != nil || !bytes.Equal(key, first) {
+		t.Errorf("expected key %d to be kept. Got %x (%v)", 1, key, err)
+	}
+
+	if _, err := f.Key(3); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected %v. Got %v", ErrUnknownKey, err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := stat.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected permissions %o. Got %o", 0600, perm)
+	}
+
+	tests := map[string]string{
+		"Empty":     "# no keys\n",
+		"Zero":      "0 000102030405060708090a0b0c0d0e0f\n",
+		"Size":      "1 0001020304\n",
+		"Hex":       "1 not-hex\n",
+		"Duplicate": "1 000102030405060708090a0b0c0d0e0f\n1 101112131415161718191a1b1c1d1e1f\n",
+	}
+
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := OpenFile(path); err == nil {
+				t.Errorf("expected error opening keyring:\n%s", contents)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package log
+
+import (
+	"slices"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+)
+
+// Compaction configures the key-based compaction of a [Log]. When enabled, a
+// background cleaner rewrites sealed segments to keep only the newest record
+// for each key. Records without a key are never removed by compaction.
+type Compaction struct {
+	Enabled bool // Enabled starts the cleaner.
+
+	// TombstoneRetention is how long a tombstone is kept after it is appended,
+	// giving consumers the chance to observe the deletion. Tombstones are only
+	// removed once they are the newest record for their key.
+	TombstoneRetention time.Duration
+	Interval           time.Duration // Interval is how often the cleaner runs.
+}
+
+// clean compacts every sealed segment as of [now]. The newest offset of each
+// key is first found by reading the entire log, including the active segment,
+// since it may hold records superseding those of sealed segments. Only sealed
+// segments holding something to remove are rewritten.
+//
+// The segments are pinned, then read and rewritten without the lock, which is
+// only taken to swap the cleaned segments into place. A segment removed from
+// the log in the meantime is not replaced, and nothing is replaced if the log
+// was truncated, since the newest record of a key may have been discarded.
+//
+// NOTE: The newest offset of every key is held in memory, which bounds the
+// number of distinct keys a log can reasonably hold.
+func (l *Log) clean(now time.Time) (err error) {
+	l.mu.RLock()
+	segments := slices.Clone(l.segments)
+	truncations := l.truncations
+
+	for _, seg := range segments {
+		seg.Pin()
+	}
+	l.mu.RUnlock()
+
+	defer func() {
+		for _, seg := range segments {
+			if uerr := seg.Unpin(); err == nil {
+				err = uerr
+			}
+		}
+	}()
+
+	latest := make(map[string]uint64)
+	for _, seg := range segments {
+		err := seg.Scan(seg.BaseOffset, func(rec *record.Record) bool {
+			if rec.Key != nil {
+				latest[string(rec.Key)] = rec.Offset
+			}
+
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	keep := func(rec *record.Record) bool {
+		if rec.Key == nil {
+			return true
+		}
+
+		if latest[string(rec.Key)] != rec.Offset {
+			return false
+		}
+
+		return !rec.IsTombstone() || now.Sub(rec.Timestamp) < l.Compaction.TombstoneRetention
+	}
+
+	// The active segment is always the last segment, so it is never rewritten.
+	var rewritten []*segment.Rewritten
+	for _, seg := range segments[:len(segments)-1] {
+		dirty, err := anyRemoved(seg, keep)
+		if err == nil && dirty {
+			var r *segment.Rewritten
+			if r, err = seg.Rewrite(keep); err == nil {
+				rewritten = append(rewritten, r)
+			}
+		}
+
+		if err != nil {
+			discard(rewritten)
+
+			return err
+		}
+	}
+
+	if len(rewritten) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.replace(rewritten, truncations)
+
+	return err
+}
+
+// replace swaps each segment in [rewritten] into the log in place of the one
+// it was rewritten from, returning the number replaced. A segment removed from
+// the log since it was rewritten is discarded instead, and so is every segment
+// if the log was truncated since [truncations], since a rewritten segment may
+// hold records which have been discarded. The caller must hold the lock.
+func (l *Log) replace(rewritten []*segment.Rewritten, truncations uint64) (int, error) {
+	if l.truncations != truncations {
+		return 0, discard(rewritten)
+	}
+
+	var n int
+	for k, r := range rewritten {
+		// The segment was deleted by retention, offloaded or rewritten again.
+		i := slices.Index(l.segments, r.Original())
+		if i < 0 {
+			if err := r.Discard(); err != nil {
+				discard(rewritten[k+1:])
+
+				return n, err
+			}
+
+			continue
+		}
+
+		replaced, err := r.Replace()
+		if err != nil {
+			discard(rewritten[k+1:])
+
+			return n, err
+		}
+
+		l.segments[i] = replaced
+		n++
+	}
+
+	return n, nil
+}
+
+// discard discards every segment in [rewritten], returning the first error.
+func discard(rewritten []*segment.Rewritten) error {
+	var err error
+	for _, r := range rewritten {
+		if derr := r.Discard(); err == nil {
+			err = derr
+		}
+	}
+
+	return err
+}
+
+// anyRemoved reports whether [keep] rejects any record in [seg], in which case
+// the segment needs to be cleaned.
+func anyRemoved(seg *segment.Segment, keep func(*record.Record) bool) (bool, error) {
+	var dirty bool
+
+	err := seg.Scan(seg.BaseOffset, func(rec *record.Record) bool {
+		dirty = !keep(rec)
+
+		return !dirty
+	})
+
+	return dirty, err
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"slices"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+)
+
+// Reencrypt rewrites every sealed segment which is not encrypted under the
+// current key of [segment.Config.Keys], returning the number of segments
+// rewritten.
+//
+// Segments encrypted under an older key remain readable for as long as the
+// keyring holds it, so this is only needed before retiring a key. The active
+// segment keeps the key it was created with until it is sealed. Segments
+// offloaded to the object store (see [Tiering]) are fetched, rewritten and
+// uploaded again. Those offloaded before their key was recorded are always
+// rewritten.
+//
+// Like compaction, segments are pinned and rewritten without the lock, which is
+// only taken to swap them in, so readers and writers are not held up by the
+// rewrite. Any segment replaced or removed in the meantime is left as it is.
+func (l *Log) Reencrypt() (n int, err error) {
+	var current uint32
+	if keys := l.Config.Segment.Keys; keys != nil {
+		id, _, err := keys.Current()
+		if err != nil {
+			return 0, err
+		}
+
+		current = id
+	}
+
+	// The active segment is always the last segment, so it is never rewritten.
+	l.mu.RLock()
+	segments := slices.Clone(l.segments[:len(l.segments)-1])
+	remote := slices.Clone(l.remote)
+	truncations := l.truncations
+
+	for _, seg := range segments {
+		seg.Pin()
+	}
+	l.mu.RUnlock()
+
+	defer func() {
+		for _, seg := range segments {
+			if uerr := seg.Unpin(); err == nil {
+				err = uerr
+			}
+		}
+	}()
+
+	keepAll := func(*record.Record) bool {
+		return true
+	}
+
+	// A rewritten segment is written under the current key.
+	var rewritten []*segment.Rewritten
+	for _, seg := range segments {
+		if seg.KeyID() == current {
+			continue
+		}
+
+		r, err := seg.Rewrite(keepAll)
+		if err != nil {
+			discard(rewritten)
+
+			return 0, err
+		}
+
+		rewritten = append(rewritten, r)
+	}
+
+	// Segments uploaded before a failure are still adopted.
+	var uploaded []remoteSegment
+	for _, r := range remote {
+		if r.KeyID == current {
+			continue
+		}
+
+		var u remoteSegment
+		if u, err = l.reencryptRemote(r); err != nil {
+			break
+		}
+
+		uploaded = append(uploaded, u)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, rerr := l.replace(rewritten, truncations)
+	if err == nil {
+		err = rerr
+	}
+
+	m, rerr := l.replaceRemote(uploaded)
+	if err == nil {
+		err = rerr
+	}
+
+	return n + m, err
+}
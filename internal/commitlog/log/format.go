@@ -0,0 +1,97 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+)
+
+const (
+	// formatFile is the name of the file in a log's directory recording the
+	// version of the on-disk format of its segments.
+	formatFile = "format"
+
+	// formatVersion is the version of the on-disk format written by this
+	// package. The files of segments in version 1 lack the header written by
+	// [segment.New].
+	formatVersion = 2
+
+	// baselineVersion is the version of a directory without a format file,
+	// which predates checksums and batches as well as headers (see
+	// [segment.Migrate]).
+	baselineVersion = 0
+)
+
+// ErrFormat is returned when a log's directory was written in a format newer
+// than this package understands.
+var ErrFormat = errors.New("unsupported log format")
+
+// readFormat returns the format version of the log in [dir], which must not be
+// newer than [formatVersion].
+func readFormat(dir string) (uint64, error) {
+	b, err := os.ReadFile(filepath.Join(dir, formatFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if version > formatVersion {
+		return 0, fmt.Errorf("%w: version %d", ErrFormat, version)
+	}
+
+	return version, nil
+}
+
+// writeFormat records the current format version in [dir]. The previous format
+// file is atomically replaced.
+func writeFormat(dir string) error {
+	path := filepath.Join(dir, formatFile)
+	if err := os.WriteFile(path+".tmp", []byte(fmt.Sprintln(formatVersion)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// migrate brings [segments], read from a directory with the format [version],
+// up to date by rewriting each in the current format. The format file is only
+// updated once every segment is rewritten, so an interrupted migration is
+// simply repeated. Segments of the baseline layout were already rewritten as
+// they were opened.
+func migrate(dir string, version uint64, segments []*segment.Segment) error {
+	if version == formatVersion {
+		return nil
+	}
+
+	if version == baselineVersion {
+		return writeFormat(dir)
+	}
+
+	keepAll := func(*record.Record) bool { return true }
+
+	for i, seg := range segments {
+		migrated, _, err := seg.Clean(keepAll)
+		if err != nil {
+			return fmt.Errorf("migrating segment %d: %w", seg.BaseOffset, err)
+		}
+
+		segments[i] = migrated
+	}
+
+	return writeFormat(dir)
+}
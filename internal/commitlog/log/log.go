@@ -3,21 +3,38 @@
 package log
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
 	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
 )
 
 const (
-	defaultMaxIndex = (1 << 10)
-	defaultMaxStore = (1 << 10)
+	defaultMaxIndex     = (1 << 10)
+	defaultMaxStore     = (1 << 10)
+	defaultSyncRecords  = 64
+	defaultSyncInterval = time.Second
+	defaultTimeInterval = (1 << 12)
+
+	defaultRetentionInterval  = time.Minute
+	defaultCompactionInterval = time.Minute
+	defaultTieringInterval    = time.Minute
+	defaultCacheSegments      = 4
+
+	// Expired segments are rolled no later than this after expiring, or after
+	// [segment.Config.MaxSegmentAge] if it is shorter.
+	defaultRollInterval = time.Minute
 )
 
 // ErrOutOfBounds occurs when no segment in the Log contains the given offset.
@@ -29,19 +46,72 @@ func (e ErrOutOfBounds) Error() string {
 	return fmt.Sprintf("offset %d out of range", e.offset)
 }
 
+// Unwrap allows callers using any [storage.Log] to test for
+// [storage.ErrOutOfBounds].
+func (e ErrOutOfBounds) Unwrap() error {
+	return storage.ErrOutOfBounds
+}
+
+// ErrBatchTooLarge is returned when a batch holds more records than the index
+// of a single segment can hold.
+var ErrBatchTooLarge = storage.ErrBatchTooLarge
+
+// ErrTimeOutOfBounds is returned when no record in the Log has a timestamp at
+// or after the requested time.
+var ErrTimeOutOfBounds = storage.ErrTimeOutOfBounds
+
 // Config is the configuration for the log.
 type Config struct {
-	Segment segment.Config // Segment configures the log segments.
+	Segment    segment.Config // Segment configures the log segments.
+	Retention  Retention      // Retention configures the deletion of old segments.
+	Compaction Compaction     // Compaction configures the removal of superseded records.
+	Tiering    Tiering        // Tiering configures the offloading of sealed segments.
 }
 
-// Log is a list of segments with a pointer to the active segment.
+// Log is a list of segments with a pointer to the active segment. It is the
+// disk engine implementing [storage.Log].
+//
+// Appends are serialized by [wmu] and only share [mu] with readers, since the
+// active segment may be read concurrently with its writer (see
+// [segment.Segment.Watermark]). The exclusive lock is reserved for changing
+// the list of segments and for operations touching a segment's writer state,
+// such as syncing it.
 type Log struct {
 	mu     sync.RWMutex // shared readers, exclusive writers. I always forget this.
+	wmu    sync.Mutex   // wmu serializes appends. It is acquired before [mu].
 	Dir    string       // Dir is the directory in which the store and index is kept.
 	Config              // Config is the configuration of the log
 
+	// Recovery totals the data discarded from each segment while reopening the
+	// log after an unclean shutdown.
+	Recovery segment.Recovery
+
+	// Damaged lists the sealed segments found damaged while reopening the log.
+	// Each is truncated at its last intact batch like the active segment, so
+	// the records from the damage to the end of the segment are lost, but the
+	// rest of the log remains available.
+	Damaged []segment.ErrCorrupt
+
 	segments      []*segment.Segment
 	activeSegment *segment.Segment
+
+	// remote lists the segments offloaded to [Tiering.Store], which precede
+	// [segments]. cache holds those fetched back to local disk, least recently
+	// used first, and is guarded by [cmu], which is acquired after [mu].
+	remote []remoteSegment
+	cmu    sync.Mutex
+	cache  []*segment.Segment
+
+	// truncations counts calls to [Log.Truncate], so that work done without
+	// the lock can tell whether records it read have since been discarded.
+	truncations uint64
+
+	done  chan struct{}  // done is closed to stop background goroutines.
+	wg    sync.WaitGroup // wg tracks running background goroutines.
+	bgErr error          // bgErr is the last error from a background goroutine.
+
+	closeOnce sync.Once // closeOnce ensures the log is only closed once.
+	closeErr  error     // closeErr is the result of closing the log.
 }
 
 func setup(dir string, c Config) (*Log, error) {
@@ -54,18 +124,43 @@ func setup(dir string, c Config) (*Log, error) {
 	var baseOffsets []uint64
 	// Files have the following form: <offset>.<index|store>. Getting the base
 	// offset is a matter of slicing off the suffix and converting to an int.
+	//
+	// Only stores are considered, as they are the source of truth for a segment.
+	// A missing or damaged index is rebuilt from its store by [segment.New],
+	// whereas an index without a store has nothing left to describe.
 	for _, file := range files {
 		name := file.Name()
+
+		// A segment was being cleaned when the log was last closed. The segment
+		// itself is intact (see [segment.Segment.Clean]).
+		if file.IsDir() && strings.HasPrefix(name, segment.CleanPrefix) {
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if filepath.Ext(name) != ".store" {
+			continue
+		}
+
 		prefix := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
 
+		// Anything else in the directory is none of the log's business.
 		offset, err := strconv.ParseUint(prefix, 10, 0)
 		if err != nil {
-			return nil, err
+			continue
 		}
 
 		baseOffsets = append(baseOffsets, offset)
 	}
 
+	version, err := readFormat(dir)
+	if err != nil {
+		return nil, err
+	}
+
 	// This is a brand new log. Create from the initial offset and return
 	if len(baseOffsets) == 0 {
 		seg, err := segment.New(dir, c.Segment.InitialOffset, c.Segment)
@@ -73,6 +168,10 @@ func setup(dir string, c Config) (*Log, error) {
 			return nil, err
 		}
 
+		if err := writeFormat(dir); err != nil {
+			return nil, err
+		}
+
 		return &Log{
 			Dir:           dir,
 			Config:        c,
@@ -81,26 +180,57 @@ func setup(dir string, c Config) (*Log, error) {
 		}, nil
 	}
 
-	// Sort here so later when iterating through the offsets adjacent offsets
-	// can be skipped since they will be the same for the index and store.
-	// NOTE: I am extremely unsure about this, but the book seems to think it's
-	// okay...
-	slices.SortFunc(baseOffsets, func(a, b uint64) int {
-		// This quantity could not be negative otherwise
-		return int(a) - int(b)
-	})
+	// Sort here so segments are opened in the order of the offsets they hold.
+	slices.Sort(baseOffsets)
+
+	var (
+		segments []*segment.Segment
+		recovery segment.Recovery
+		damaged  []segment.ErrCorrupt
+	)
+	// Segments older than headers are opened as such until they are migrated,
+	// except those of the baseline layout, which are migrated as they are
+	// opened.
+	var flags segment.Flag
+	if version < formatVersion {
+		flags |= segment.Legacy
+	}
+
+	open := segment.Open
+	if version == baselineVersion {
+		open = segment.Migrate
+	}
+
+	for i, off := range baseOffsets {
+		// Only the active segment may end in a torn write, since every other
+		// segment was synced when it was sealed.
+		f := flags
+		if i < len(baseOffsets)-1 {
+			f |= segment.Sealed
+		}
+
+		// Create a new segment. Any inconsistency left by a crash is repaired
+		// here, and a missing index is rebuilt.
+		s, err := open(dir, off, c.Segment, f)
+
+		// A damaged sealed segment is reported and truncated at its last intact
+		// batch, rather than leaving the rest of the log unreadable.
+		var corrupt segment.ErrCorrupt
+		if errors.As(err, &corrupt) && f&segment.Sealed != 0 {
+			damaged = append(damaged, corrupt)
+			s, err = open(dir, off, c.Segment, f&^segment.Sealed)
+		}
 
-	var segments []*segment.Segment
-	// We only need one offset per pair of index and store, so we may advance [i]
-	// by two each iteration.
-	for i := 0; i < len(baseOffsets); i += 2 {
-		// Create a new segment
-		s, err := segment.New(dir, baseOffsets[i], c.Segment)
 		if err != nil {
 			return nil, err
 		}
 
 		segments = append(segments, s)
+		recovery = recovery.Add(s.Recovery)
+	}
+
+	if err := migrate(dir, version, segments); err != nil {
+		return nil, err
 	}
 
 	// The active segment is always the last segment. This is because segments are
@@ -111,14 +241,21 @@ func setup(dir string, c Config) (*Log, error) {
 	return &Log{
 		Dir:           dir,
 		Config:        c,
+		Recovery:      recovery,
+		Damaged:       damaged,
 		segments:      segments,
 		activeSegment: active,
 	}, nil
 }
 
 // New constructs a new [Log] whose store and index are located under [dir],
-// and whose segment configuration is given by [c].
+// and whose segment configuration is given by [c]. [dir] is created if it does
+// not already exist.
 func New(dir string, c Config) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
 	// Configure defaults if not provided
 	if c.Segment.MaxIndexBytes == 0 {
 		c.Segment.MaxIndexBytes = defaultMaxIndex
@@ -128,7 +265,140 @@ func New(dir string, c Config) (*Log, error) {
 		c.Segment.MaxStoreBytes = defaultMaxStore
 	}
 
-	return setup(dir, c)
+	if c.Segment.SyncRecords == 0 {
+		c.Segment.SyncRecords = defaultSyncRecords
+	}
+
+	if c.Segment.TimeInterval == 0 {
+		c.Segment.TimeInterval = defaultTimeInterval
+	}
+
+	if c.Segment.SyncInterval == 0 {
+		c.Segment.SyncInterval = defaultSyncInterval
+	}
+
+	if c.Retention.Interval == 0 {
+		c.Retention.Interval = defaultRetentionInterval
+	}
+
+	if c.Compaction.Interval == 0 {
+		c.Compaction.Interval = defaultCompactionInterval
+	}
+
+	if c.Tiering.Interval == 0 {
+		c.Tiering.Interval = defaultTieringInterval
+	}
+
+	if c.Tiering.CacheSegments == 0 {
+		c.Tiering.CacheSegments = defaultCacheSegments
+	}
+
+	l, err := setup(dir, c)
+	if err != nil {
+		return nil, err
+	}
+
+	l.done = make(chan struct{})
+
+	if err := l.loadRemote(); err != nil {
+		l.Close()
+
+		return nil, err
+	}
+
+	// Sealed segments need no attention from the flusher since they are synced
+	// when rolled.
+	if c.Segment.Sync == segment.SyncPeriodic {
+		l.every(c.Segment.SyncInterval, func(time.Time) error {
+			return l.Sync()
+		})
+	}
+
+	if c.Retention.MaxAge > 0 || c.Retention.MaxBytes > 0 {
+		l.every(c.Retention.Interval, l.locked(l.retain))
+	}
+
+	if c.Compaction.Enabled {
+		l.every(c.Compaction.Interval, l.clean)
+	}
+
+	// Appends also roll expired segments, but a log which is not appended to
+	// must still roll them so that they can be deleted by retention.
+	if age := c.Segment.MaxSegmentAge; age > 0 {
+		l.every(min(age, defaultRollInterval), l.rollExpired)
+	}
+
+	if c.Tiering.Store != nil {
+		l.every(c.Tiering.Interval, l.offload)
+	}
+
+	return l, nil
+}
+
+// locked returns a function calling [fn] with the lock held.
+func (l *Log) locked(fn func(time.Time) error) func(time.Time) error {
+	return func(now time.Time) error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return fn(now)
+	}
+}
+
+// every starts a goroutine which calls [fn] with the current time every
+// [interval] until the log is closed. [fn] must take any locks it needs (see
+// [Log.locked]). Errors are reported by [Log.Err].
+func (l *Log) every(interval time.Duration, fn func(time.Time) error) {
+	l.wg.Add(1)
+
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.done:
+				return
+			case now := <-ticker.C:
+				if err := fn(now); err != nil {
+					l.mu.Lock()
+					l.bgErr = err
+					l.mu.Unlock()
+				}
+			}
+		}
+	}()
+}
+
+// Sync commits every record appended to the log so far to stable storage.
+//
+// Sync is serialized with appends, which also sync the active segment, but
+// only takes the shared lock to keep the active segment in place, so readers
+// are not held up by the flush.
+func (l *Log) Sync() error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Only the active segment may hold unsynced records.
+	return l.activeSegment.Sync()
+}
+
+// Err returns the last error encountered by a background goroutine, such as
+// the flusher, retention or compaction, since the last call to Err. Background
+// errors do not fail appends or syncs, which they are unrelated to.
+func (l *Log) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.bgErr
+	l.bgErr = nil
+
+	return err
 }
 
 // Append appends a [record] to the log's active segment, returning its offset.
@@ -138,55 +408,243 @@ func New(dir string, c Config) (*Log, error) {
 // If an error occurs when appending the [record], an offset of 0 is returned
 // along with the error.
 func (l *Log) Append(record *record.Record) (uint64, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
 
+	l.mu.RLock()
 	off, err := l.activeSegment.Append(record)
+	full := l.activeSegment.IsFull()
+	l.mu.RUnlock()
+
 	if err != nil {
 		return 0, err
 	}
 
 	// If the active segment is full, create a new segment at the next offset
-	// and promote to active segment.
-	if l.activeSegment.IsFull() {
-		s, err := segment.New(l.Dir, off+1, l.Config.Segment)
-		if err != nil {
+	// and promote to active segment. The offset of the record just appended is
+	// still returned to the caller.
+	if full {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	return off, nil
+}
+
+// AppendBatch appends every record in [records] to the log under a single
+// acquisition of its lock, returning the offset of the first. The records are
+// assigned contiguous offsets and always land in the same segment: if the
+// batch does not fit in the active segment, a new segment is rolled before
+// anything is written. A failure to roll therefore leaves the log untouched.
+//
+// [ErrBatchTooLarge] is returned if the batch would not fit in even an empty
+// segment.
+func (l *Log) AppendBatch(records []*record.Record) (uint64, error) {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	// Only appends modify the active segment, so it may be inspected without
+	// [mu] while holding [wmu].
+	if !l.activeSegment.Fits(len(records)) {
+		// Rolling an empty segment cannot make any more room.
+		if l.activeSegment.NextOffset == l.activeSegment.BaseOffset {
+			return 0, ErrBatchTooLarge
+		}
+
+		if err := l.roll(); err != nil {
 			return 0, err
 		}
 
-		l.segments = append(l.segments, s)
-		l.activeSegment = s
+		if !l.activeSegment.Fits(len(records)) {
+			return 0, ErrBatchTooLarge
+		}
+	}
+
+	l.mu.RLock()
+	off, err := l.activeSegment.AppendBatch(records)
+	full := l.activeSegment.IsFull()
+	l.mu.RUnlock()
 
-		return off + 1, nil
+	if err != nil {
+		return 0, err
+	}
+
+	if full {
+		if err := l.roll(); err != nil {
+			return 0, err
+		}
 	}
 
 	return off, nil
 }
 
-// Read retrieves the record stored at [off]. The correct segment is chosen via
-// linear search through the Log's segments. If [off] is outside the range of
-// any segment, [ErrOutOfBounds] is returned.
-//
-// NOTE: Can we do anything about the linear search? Aren't these segments in
-// increasing order???
-func (l *Log) Read(off uint64) (*record.Record, error) {
+// roll seals the active segment and promotes a new segment beginning at the
+// next offset. The caller must hold [wmu], but not [mu]. Readers are only
+// excluded while the new segment is added to the log.
+func (l *Log) roll() error {
+	l.mu.RLock()
+	// Seal the current segment by committing it to stable storage.
+	err := l.activeSegment.Sync()
+	l.mu.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	s, err := segment.New(l.Dir, l.activeSegment.NextOffset, l.Config.Segment)
+	if err != nil {
+		return err
+	}
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	l.segments = append(l.segments, s)
+	l.activeSegment = s
+
+	return nil
+}
+
+// rollExpired rolls the active segment if it has expired as of [now] (see
+// [segment.Segment.Expired]).
+func (l *Log) rollExpired(now time.Time) error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	// Only appends modify the active segment, so it may be inspected without
+	// [mu] while holding [wmu].
+	if !l.activeSegment.Expired(now) {
+		return nil
+	}
+
+	return l.roll()
+}
+
+// Read retrieves the record stored at [off]. The correct segment is found by
+// binary search through the Log's segments. If [off] is outside the range of
+// any segment or its record was removed by compaction, [ErrOutOfBounds] is
+// returned.
+//
+// Read only takes the shared lock, so readers proceed concurrently with each
+// other and with appends. Records still being appended are not yet visible.
+// Records preceding the local segments are read from those offloaded to the
+// object store (see [Tiering]).
+func (l *Log) Read(off uint64) (*record.Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	seg := l.segmentFor(off)
+	if seg == nil {
+		return l.readRemote(off)
+	}
+
+	if off >= seg.Watermark() {
+		return nil, ErrOutOfBounds{off}
+	}
+
+	rec, err := seg.Read(off)
+	if err == io.EOF {
+		return nil, ErrOutOfBounds{off}
+	}
+
+	return rec, err
+}
+
+// Range calls [fn] with each record in the log in order, beginning with the
+// first at or after offset [from], until [fn] returns false. Like [Log.Read],
+// Range only takes the shared lock, and records still being appended are not
+// visible. [fn] must not modify the log.
+func (l *Log) Range(from uint64, fn func(*record.Record) bool) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if more, err := l.rangeRemote(from, fn); err != nil || !more {
+		return err
+	}
+
+	// Skip the segments ending before [from].
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].Watermark() > from
+	})
+
+	more := true
+	for _, seg := range l.segments[i:] {
+		err := seg.Scan(max(from, seg.BaseOffset), func(rec *record.Record) bool {
+			more = fn(rec)
+
+			return more
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// segmentFor returns the segment whose offsets begin at or before [off] and
+// precede those of the following segment, or nil if [off] precedes the log.
+// Segments are sorted by [segment.Segment.BaseOffset], so this is a binary
+// search. The caller must hold the lock.
+func (l *Log) segmentFor(off uint64) *segment.Segment {
+	i := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].BaseOffset > off
+	})
+
+	if i == 0 {
+		return nil
+	}
+
+	return l.segments[i-1]
+}
+
+// OffsetForTime returns the offset of the first record in the log whose
+// timestamp is at or after [t]. Segments whose records are all earlier than [t]
+// are skipped without being read. If every record is earlier than [t],
+// [ErrTimeOutOfBounds] is returned. Like [Log.Read], OffsetForTime only takes
+// the shared lock, and records still being appended are not visible.
+func (l *Log) OffsetForTime(t time.Time) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if off, ok, err := l.offsetForTimeRemote(t); err != nil || ok {
+		return off, err
+	}
+
 	for _, seg := range l.segments {
-		if seg.BaseOffset <= off && off < seg.NextOffset {
-			return seg.Read(off)
+		off, ok, err := seg.OffsetForTime(t)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok {
+			return off, nil
 		}
 	}
 
-	return nil, ErrOutOfBounds{off}
+	return 0, ErrTimeOutOfBounds
 }
 
-// Close closes each segment in the log.
+// Close stops any background goroutines and closes each segment in the log.
+// Closing the log again returns the result of the first call.
 //
 // NOTE: This does not remove the files backing the segment. The Remove method
 // is instead responsible for completely removing the underlying files.
 func (l *Log) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.close()
+	})
+
+	return l.closeErr
+}
+
+// close implements [Log.Close].
+func (l *Log) close() error {
+	// Background goroutines acquire the lock, so they must exit first.
+	close(l.done)
+	l.wg.Wait()
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
@@ -196,7 +654,7 @@ func (l *Log) Close() error {
 		}
 	}
 
-	return nil
+	return l.closeCache()
 }
 
 // Remove closes the log and removes ALL files in its backing directory. As a
@@ -212,56 +670,142 @@ func (l *Log) Remove() error {
 }
 
 // LowestOffset retrieves the [BaseOffset] of the first segment. This is always
-// the lowest offset since segments are totally ordered in the log.
+// the lowest offset since segments are totally ordered in the log. It is the
+// start of the log, and advances as segments are deleted by [Retention].
+// Segments offloaded to the object store count as part of the log.
 func (l *Log) LowestOffset() uint64 {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if len(l.remote) > 0 {
+		return l.remote[0].BaseOffset
+	}
 
 	return l.segments[0].BaseOffset
 }
 
-// HighestOffset returns the highest occupied offset, that is, the [NextOffset]
-// of the last segment minus 1 position. If the log is empty, this method will
-// return 0.
+// HighestOffset returns the highest offset visible to readers, that is, the
+// watermark of the last segment minus 1 position. If the log is empty, this
+// method will return 0.
 func (l *Log) HighestOffset() uint64 {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
 
 	// Isn't this always equivalent to the active segment??? This book is very
 	// confusing...
 	//
 	// TODO: Verify whether the active segment is always the one at the end of
 	// the slice.
-	if off := l.segments[len(l.segments)-1].NextOffset; off > 0 {
+	if off := l.segments[len(l.segments)-1].Watermark(); off > 0 {
 		return off - 1
 	}
 
 	return 0
 }
 
-// Compact eliminates segments whose higest offset is lower than [lowest].
+// Compact eliminates segments whose higest offset is lower than [lowest]. The
+// active segment is never removed. Offloaded segments are deleted from the
+// object store first.
 func (l *Log) Compact(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	// NOTE: It's like the author completely forgot we sorted these segments...
-	// We only need to find the first segment whose highest offset is above the
-	// threshold. Iterating over any segment after this is pointless!! As before,
-	// I'm almost certain we could find such a segment in O(log n) instead of a
-	// linear search.
-	var segments []*segment.Segment
-	for _, seg := range l.segments {
-		if seg.NextOffset < lowest {
-			if err := seg.Remove(); err != nil {
-				return err
-			}
 
-			continue
+	r := sort.Search(len(l.remote), func(i int) bool {
+		return l.remote[i].NextOffset >= lowest
+	})
+
+	if err := l.removeRemote(r); err != nil {
+		return err
+	}
+
+	// The local segments follow the remaining offloaded segments.
+	if len(l.remote) > 0 {
+		return nil
+	}
+
+	// Segments are sorted, so the segments to remove form a prefix of the log
+	// ending at the first segment whose highest offset is above the threshold.
+	n := sort.Search(len(l.segments)-1, func(i int) bool {
+		return l.segments[i].NextOffset >= lowest
+	})
+
+	return l.removePrefix(n)
+}
+
+// Truncate discards every record in the log after offset [off]. Segments
+// beginning after [off] are removed, and the segment holding [off] is truncated
+// and becomes the active segment again. The next record appended follows the
+// last record kept. [ErrOutOfBounds] is returned if [off] precedes the log, or
+// the segments on local disk, since offloaded segments cannot be truncated.
+//
+// Segments are removed from the end of the log, so a crash part way through
+// leaves a shorter log rather than one with holes in it.
+func (l *Log) Truncate(off uint64) error {
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+
+	l.mu.Lock()
+
+	if off < l.segments[0].BaseOffset {
+		l.mu.Unlock()
+
+		return ErrOutOfBounds{off}
+	}
+
+	l.truncations++
+
+	// The first segment always begins at or before [off], so at least one is
+	// kept.
+	n := sort.Search(len(l.segments), func(i int) bool {
+		return l.segments[i].BaseOffset > off
+	})
+
+	for k := len(l.segments) - 1; k >= n; k-- {
+		if err := l.segments[k].Remove(); err != nil {
+			l.mu.Unlock()
+
+			return err
 		}
 
-		segments = append(segments, seg)
+		l.segments[k] = nil
+		l.segments = l.segments[:k]
+	}
+
+	l.activeSegment = l.segments[n-1]
+
+	err := l.activeSegment.Truncate(off)
+	full := l.activeSegment.IsFull()
+	l.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// The segment may have been sealed because it was full.
+	if full {
+		return l.roll()
+	}
+
+	return nil
+}
+
+// removePrefix removes the first [n] segments of the log. The remaining
+// segments are resliced rather than copied, and the removed ones are cleared
+// from the underlying array so they can be collected. The caller must hold the
+// lock.
+func (l *Log) removePrefix(n int) error {
+	for i, seg := range l.segments[:n] {
+		if err := seg.Remove(); err != nil {
+			// Keep the segments which have not been removed.
+			clear(l.segments[:i])
+			l.segments = l.segments[i:]
+
+			return err
+		}
 	}
 
-	l.segments = segments
+	clear(l.segments[:n])
+	l.segments = l.segments[n:]
 
 	return nil
 }
@@ -0,0 +1,1310 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/tier"
+	"github.com/beautifultovarisch/dlog/internal/schema"
+)
+
+// Index entries are 12 bytes wide, so each segment holds 3 records.
+const recordWidth = 12
+
+func TestLog(t *testing.T) {
+	config := Config{
+		Segment: segment.Config{MaxIndexBytes: recordWidth * 3},
+	}
+
+	run := func(name string, fn func(l *Log, t *testing.T)) {
+		t.Run(name, func(t *testing.T) {
+			l, err := New(t.TempDir(), config)
+			if err != nil {
+				t.Fatalf("error creating log: %v", err)
+			}
+
+			t.Cleanup(func() {
+				l.Close()
+			})
+
+			fn(l, t)
+		})
+	}
+
+	run("Append", func(l *Log, t *testing.T) {
+		for i := 0; i < 7; i++ {
+			off, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))})
+			if err != nil {
+				t.Fatalf("error appending record: %v", err)
+			}
+
+			if off != uint64(i) {
+				t.Errorf("expected offset of %d. Got %d", i, off)
+			}
+		}
+
+		// Segments roll as soon as they are full.
+		if n := len(l.segments); n != 3 {
+			t.Errorf("expected %d segments. Got %d", 3, n)
+		}
+
+		for i := 0; i < 7; i++ {
+			rec, err := l.Read(uint64(i))
+			if err != nil {
+				t.Fatalf("error reading record: %v", err)
+			}
+
+			if actual := string(rec.Value); actual != fmt.Sprint(i) {
+				t.Errorf("expected value %d. Got %s", i, actual)
+			}
+		}
+
+		if _, err := l.Read(7); err == nil {
+			t.Error("expected error reading beyond the end of the log")
+		}
+	})
+
+	run("Truncate", func(l *Log, t *testing.T) {
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.Truncate(4); err != nil {
+			t.Fatalf("error truncating log: %v", err)
+		}
+
+		if n := len(l.segments); n != 2 {
+			t.Errorf("expected %d segments. Got %d", 2, n)
+		}
+
+		if high := l.HighestOffset(); high != 4 {
+			t.Errorf("expected highest offset of %d. Got %d", 4, high)
+		}
+
+		if _, err := l.Read(5); err == nil {
+			t.Error("expected error reading truncated record")
+		}
+
+		// The truncated segment is active again.
+		off, err := l.Append(&record.Record{Value: []byte("5")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if off != 5 {
+			t.Errorf("expected offset of %d. Got %d", 5, off)
+		}
+
+		// The first segment is full, so a new segment follows it.
+		if err := l.Truncate(2); err != nil {
+			t.Fatal(err)
+		}
+
+		if off, err := l.Append(&record.Record{}); err != nil || off != 3 {
+			t.Errorf("expected offset of %d. Got %d (%v)", 3, off, err)
+		}
+
+		for i := 0; i < 3; i++ {
+			rec, err := l.Read(uint64(i))
+			if err != nil {
+				t.Fatalf("error reading record: %v", err)
+			}
+
+			if actual := string(rec.Value); actual != fmt.Sprint(i) {
+				t.Errorf("expected value %d. Got %s", i, actual)
+			}
+		}
+
+		if err := l.Truncate(0); err != nil {
+			t.Fatal(err)
+		}
+
+		if high := l.HighestOffset(); high != 0 || len(l.segments) != 1 {
+			t.Errorf("expected a single record. Got %d segments ending at %d", len(l.segments), high)
+		}
+	})
+
+	t.Run("Age", func(t *testing.T) {
+		c := config
+		c.Segment.MaxSegmentAge = 10 * time.Millisecond
+
+		l, err := New(t.TempDir(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		if _, err := l.Append(&record.Record{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The segment is rolled in the background once it expires, although
+		// nothing more is appended.
+		deadline := time.Now().Add(time.Second)
+		for l.segmentCount() < 2 && time.Now().Before(deadline) {
+			time.Sleep(c.Segment.MaxSegmentAge)
+		}
+
+		if n := l.segmentCount(); n != 2 {
+			t.Fatalf("expected %d segments. Got %d", 2, n)
+		}
+
+		// An empty segment is never rolled.
+		time.Sleep(5 * c.Segment.MaxSegmentAge)
+
+		if n := l.segmentCount(); n != 2 {
+			t.Errorf("expected %d segments. Got %d", 2, n)
+		}
+
+		if err := l.Err(); err != nil {
+			t.Errorf("error from background roll: %v", err)
+		}
+	})
+
+	run("Close", func(l *Log, t *testing.T) {
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The registry of topics and the cleanup may both close a log.
+		if err := l.Close(); err != nil {
+			t.Errorf("error closing log again: %v", err)
+		}
+	})
+
+	run("Err", func(l *Log, t *testing.T) {
+		bg := errors.New("background failure")
+
+		l.mu.Lock()
+		l.bgErr = bg
+		l.mu.Unlock()
+
+		// A background error is unrelated to the records being synced.
+		if err := l.Sync(); err != nil {
+			t.Errorf("expected sync to succeed. Got %v", err)
+		}
+
+		if err := l.Err(); err != bg {
+			t.Errorf("expected %v. Got %v", bg, err)
+		}
+
+		if err := l.Err(); err != nil {
+			t.Errorf("expected error to be cleared. Got %v", err)
+		}
+	})
+
+	run("AppendBatch", func(l *Log, t *testing.T) {
+		if _, err := l.Append(&record.Record{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Only two records fit in the active segment, so the batch is written to a
+		// new segment in its entirety.
+		batch := []*record.Record{{}, {}, {}}
+
+		base, err := l.AppendBatch(batch)
+		if err != nil {
+			t.Fatalf("error appending batch: %v", err)
+		}
+
+		if base != 1 {
+			t.Errorf("expected base offset of %d. Got %d", 1, base)
+		}
+
+		if n := len(l.segments); n != 3 {
+			t.Fatalf("expected %d segments. Got %d", 3, n)
+		}
+
+		if seg := l.segments[1]; seg.BaseOffset != 1 || seg.NextOffset != 4 {
+			t.Errorf("batch straddles segments: [%d, %d)", seg.BaseOffset, seg.NextOffset)
+		}
+
+		if _, err := l.AppendBatch(make([]*record.Record, 4)); err != ErrBatchTooLarge {
+			t.Errorf("expected ErrBatchTooLarge. Got %v", err)
+		}
+	})
+
+	run("OffsetForTime", func(l *Log, t *testing.T) {
+		start := time.UnixMilli(1_000_000)
+
+		// Seven records span three segments, one second apart.
+		for i := 0; i < 7; i++ {
+			ts := start.Add(time.Duration(i) * time.Second)
+			if _, err := l.Append(&record.Record{Timestamp: ts}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for i := 0; i < 7; i++ {
+			off, err := l.OffsetForTime(start.Add(time.Duration(i)*time.Second - time.Millisecond))
+			if err != nil {
+				t.Fatalf("error looking up time: %v", err)
+			}
+
+			if off != uint64(i) {
+				t.Errorf("expected offset %d. Got %d", i, off)
+			}
+		}
+
+		if _, err := l.OffsetForTime(start.Add(time.Hour)); err != ErrTimeOutOfBounds {
+			t.Errorf("expected ErrTimeOutOfBounds. Got %v", err)
+		}
+
+		// Lookups proceed concurrently with appends, and see the records which
+		// were appended before they began.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for i := 7; i < 20; i++ {
+				if _, err := l.Append(&record.Record{Timestamp: start.Add(time.Duration(i) * time.Second)}); err != nil {
+					t.Error(err)
+
+					return
+				}
+			}
+		}()
+
+		for i := 0; i < 7; i++ {
+			if off, err := l.OffsetForTime(start.Add(time.Duration(i) * time.Second)); err != nil || off != uint64(i) {
+				t.Errorf("expected offset %d. Got %d (%v)", i, off, err)
+			}
+		}
+
+		<-done
+	})
+
+	run("Compact", func(l *Log, t *testing.T) {
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Segments hold records [0, 2], [3, 5] and [6].
+		tests := []struct {
+			lowest, expected uint64
+		}{
+			{0, 0},
+			{4, 3},
+			// The active segment is never removed.
+			{100, 6},
+		}
+
+		for _, test := range tests {
+			if err := l.Compact(test.lowest); err != nil {
+				t.Fatalf("error compacting below %d: %v", test.lowest, err)
+			}
+
+			if low := l.LowestOffset(); low != test.expected {
+				t.Errorf("expected lowest offset of %d. Got %d", test.expected, low)
+			}
+
+			if _, err := l.Read(test.expected); err != nil {
+				t.Errorf("error reading lowest offset: %v", err)
+			}
+		}
+
+		if _, err := l.Read(5); err == nil {
+			t.Error("expected error reading compacted record")
+		}
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		start := time.UnixMilli(1_000_000)
+
+		tests := map[string]struct {
+			retention Retention
+			lowest    uint64
+		}{
+			// Segments hold records [0, 2], [3, 5] and [6]. The first segment's
+			// newest record is 2s old at the time retention is enforced.
+			"MaxAge":    {Retention{MaxAge: time.Second + time.Millisecond}, 3},
+			"MaxBytes":  {Retention{MaxBytes: 1}, 6},
+			"Unlimited": {Retention{MaxAge: time.Hour, MaxBytes: 1 << 20}, 0},
+		}
+
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				c := config
+				c.Retention = test.retention
+
+				l, err := New(t.TempDir(), c)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				t.Cleanup(func() {
+					l.Close()
+				})
+
+				for i := 0; i < 7; i++ {
+					ts := start.Add(time.Duration(i) * time.Second)
+					if _, err := l.Append(&record.Record{Timestamp: ts}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				l.mu.Lock()
+				err = l.retain(start.Add(4 * time.Second))
+				l.mu.Unlock()
+
+				if err != nil {
+					t.Fatalf("error enforcing retention: %v", err)
+				}
+
+				if low := l.LowestOffset(); low != test.lowest {
+					t.Errorf("expected lowest offset of %d. Got %d", test.lowest, low)
+				}
+
+				if test.lowest > 0 {
+					if _, err := l.Read(test.lowest - 1); err == nil {
+						t.Error("expected error reading deleted record")
+					}
+				}
+
+				// The active segment is never deleted.
+				if _, err := l.Read(6); err != nil {
+					t.Errorf("error reading active segment: %v", err)
+				}
+			})
+		}
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		dir := t.TempDir()
+
+		l, err := New(dir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err = New(dir, config)
+		if err != nil {
+			t.Fatalf("error reopening log: %v", err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		if low, high := l.LowestOffset(), l.HighestOffset(); low != 0 || high != 4 {
+			t.Errorf("expected offsets [0, 4]. Got [%d, %d]", low, high)
+		}
+
+		off, err := l.Append(&record.Record{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if off != 5 {
+			t.Errorf("expected offset of %d. Got %d", 5, off)
+		}
+	})
+
+	t.Run("Damaged", func(t *testing.T) {
+		dir := t.TempDir()
+
+		l, err := New(dir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flip the last byte of the first segment, damaging its last batch.
+		f, err := os.OpenFile(filepath.Join(dir, "0.store"), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, 1)
+		if _, err := f.ReadAt(b, info.Size()-1); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.WriteAt([]byte{^b[0]}, info.Size()-1); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		l, err = New(dir, config)
+		if err != nil {
+			t.Fatalf("error reopening damaged log: %v", err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		if len(l.Damaged) != 1 || l.Damaged[0].BaseOffset != 0 {
+			t.Errorf("expected damage to segment %d. Got %v", 0, l.Damaged)
+		}
+
+		if l.Recovery.Bytes == 0 {
+			t.Error("expected the damaged batch to be discarded")
+		}
+
+		// Only the damaged batch is lost.
+		for i := 0; i < 7; i++ {
+			rec, err := l.Read(uint64(i))
+			if i == 2 {
+				if err == nil {
+					t.Errorf("expected error reading discarded record %d", i)
+				}
+
+				continue
+			}
+
+			if err != nil || string(rec.Value) != fmt.Sprint(i) {
+				t.Errorf("expected record %d to be readable. Got %v (%v)", i, rec, err)
+			}
+		}
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		dir := t.TempDir()
+
+		l, err := New(dir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The files of a directory in version 1 of the format have no headers,
+		// and are migrated.
+		path := filepath.Join(dir, formatFile)
+		if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		stores, err := filepath.Glob(filepath.Join(dir, "*.store"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range stores {
+			b, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(name, b[header.Width:], 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Files which are not segments are ignored.
+		if err := os.WriteFile(filepath.Join(dir, "notes.store"), []byte("not a segment"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err = New(dir, config)
+		if err != nil {
+			t.Fatalf("error migrating log: %v", err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, err := l.Read(uint64(i)); err != nil {
+				t.Errorf("error reading migrated record %d: %v", i, err)
+			}
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if version, err := readFormat(dir); err != nil || version != formatVersion {
+			t.Errorf("expected format version %d. Got %d (%v)", formatVersion, version, err)
+		}
+
+		// Directories written by a newer version are refused.
+		if err := os.WriteFile(path, []byte("99\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := New(dir, config); !errors.Is(err, ErrFormat) {
+			t.Errorf("expected %v. Got %v", ErrFormat, err)
+		}
+	})
+
+	t.Run("Baseline", func(t *testing.T) {
+		dir := t.TempDir()
+
+		codec, err := schema.GetCodec(schema.RECORD_V0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Write segments as the baseline did: each record is a length prefix and
+		// the record, and each index entry a relative offset and a position,
+		// with neither checksums nor headers.
+		write := func(base uint64, values []string, tail []byte) {
+			var store, index []byte
+			for i, value := range values {
+				data, err := codec.BinaryFromNative(nil, map[string]interface{}{
+					"offset": int32(base + uint64(i)),
+					"value":  []byte(value),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				index = binary.BigEndian.AppendUint32(index, uint32(i))
+				index = binary.BigEndian.AppendUint64(index, uint64(len(store)))
+
+				store = binary.BigEndian.AppendUint64(store, uint64(len(data)))
+				store = append(store, data...)
+			}
+
+			// The active segment may end in a record torn by a crash.
+			store = append(store, tail...)
+
+			for name, b := range map[string][]byte{"store": store, "index": index} {
+				path := filepath.Join(dir, fmt.Sprintf("%d.%s", base, name))
+				if err := os.WriteFile(path, b, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		write(0, []string{"0", "1", "2"}, nil)
+		write(3, []string{"3", "4"}, []byte{0, 0, 0})
+
+		l, err := New(dir, config)
+		if err != nil {
+			t.Fatalf("error migrating log: %v", err)
+		}
+
+		for i := 0; i < 5; i++ {
+			rec, err := l.Read(uint64(i))
+			if err != nil || string(rec.Value) != fmt.Sprint(i) {
+				t.Errorf("expected migrated record %d. Got %v (%v)", i, rec, err)
+			}
+		}
+
+		if l.Recovery.Bytes != 3 {
+			t.Errorf("expected %d torn bytes discarded. Got %d", 3, l.Recovery.Bytes)
+		}
+
+		if off, err := l.Append(&record.Record{Value: []byte("5")}); err != nil || off != 5 {
+			t.Errorf("expected offset of %d. Got %d (%v)", 5, off, err)
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if version, err := readFormat(dir); err != nil || version != formatVersion {
+			t.Errorf("expected format version %d. Got %d (%v)", formatVersion, version, err)
+		}
+
+		// The migrated log reopens as it was left.
+		l, err = New(dir, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		for i := 0; i < 6; i++ {
+			if rec, err := l.Read(uint64(i)); err != nil || string(rec.Value) != fmt.Sprint(i) {
+				t.Errorf("expected record %d after reopening. Got %v (%v)", i, rec, err)
+			}
+		}
+	})
+
+	t.Run("Compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		start := time.UnixMilli(1_000_000)
+
+		c := config
+		c.Compaction = Compaction{TombstoneRetention: 5 * time.Second}
+
+		l, err := New(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Segments hold records [0, 2], [3, 5] and [6]. Record 2 has no key and
+		// record 4 is a tombstone for "b".
+		records := []record.Record{
+			{Key: []byte("a"), Value: []byte("a1")},
+			{Key: []byte("b"), Value: []byte("b1")},
+			{Value: []byte("unkeyed")},
+			{Key: []byte("a"), Value: []byte("a2")},
+			{Key: []byte("b")},
+			{Key: []byte("c"), Value: []byte("c1")},
+			{Key: []byte("a"), Value: []byte("a3")},
+		}
+
+		for i := range records {
+			records[i].Timestamp = start.Add(time.Duration(i) * time.Second)
+			if _, err := l.Append(&records[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The tombstone is 6s old and the newest record for its key.
+		if err := l.clean(start.Add(10 * time.Second)); err != nil {
+			t.Fatalf("error compacting log: %v", err)
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Compaction is preserved when the log is reopened.
+		l, err = New(dir, c)
+		if err != nil {
+			t.Fatalf("error reopening log: %v", err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		if l.Recovery != (segment.Recovery{}) {
+			t.Errorf("expected no recovery. Got %+v", l.Recovery)
+		}
+
+		for _, off := range []uint64{0, 1, 3, 4} {
+			if _, err := l.Read(off); err == nil {
+				t.Errorf("expected error reading compacted record %d", off)
+			}
+		}
+
+		for _, off := range []uint64{2, 5, 6} {
+			rec, err := l.Read(off)
+			if err != nil {
+				t.Fatalf("error reading record %d: %v", off, err)
+			}
+
+			if actual := string(rec.Value); actual != string(records[off].Value) {
+				t.Errorf("expected value %s. Got %s", records[off].Value, actual)
+			}
+
+			if !rec.Timestamp.Equal(records[off].Timestamp) {
+				t.Errorf("expected timestamp %v. Got %v", records[off].Timestamp, rec.Timestamp)
+			}
+		}
+
+		off, err := l.Append(&record.Record{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if off != 7 {
+			t.Errorf("expected offset of %d. Got %d", 7, off)
+		}
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		c := config
+		c.Segment.InitialOffset = 10
+
+		l, err := New(t.TempDir(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		// Segments hold records [10, 12], [13, 15] and [16]. Compacting away
+		// the first two records leaves a gap at the start of the log.
+		for i := 0; i < 7; i++ {
+			rec := record.Record{Key: []byte(fmt.Sprint(i % 5)), Value: []byte(fmt.Sprint(i))}
+			if _, err := l.Append(&rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.clean(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		r := l.Reader()
+
+		var snapshot bytes.Buffer
+		if _, err := io.Copy(&snapshot, r); err != nil {
+			t.Fatalf("error reading log: %v", err)
+		}
+
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The restored log is laid out differently from the original.
+		rc := config
+		rc.Segment.MaxIndexBytes = recordWidth * 2
+		rc.Segment.Codec = segment.CodecGzip
+
+		dir := t.TempDir()
+		restored, err := Restore(dir, bytes.NewReader(snapshot.Bytes()), rc)
+		if err != nil {
+			t.Fatalf("error restoring log: %v", err)
+		}
+
+		t.Cleanup(func() {
+			restored.Close()
+		})
+
+		// The restored log begins with the first record left by compaction.
+		if low, high := restored.LowestOffset(), restored.HighestOffset(); low != 12 || high != 16 {
+			t.Errorf("expected offsets [12, 16]. Got [%d, %d]", low, high)
+		}
+
+		for off := uint64(10); off <= 16; off++ {
+			expected, err := l.Read(off)
+			if err != nil {
+				if _, err := restored.Read(off); err == nil {
+					t.Errorf("expected error reading compacted record %d", off)
+				}
+
+				continue
+			}
+
+			rec, err := restored.Read(off)
+			if err != nil {
+				t.Fatalf("error reading restored record %d: %v", off, err)
+			}
+
+			if string(rec.Value) != string(expected.Value) || !rec.Timestamp.Equal(expected.Timestamp) {
+				t.Errorf("expected %+v. Got %+v", expected, rec)
+			}
+		}
+
+		if _, err := Restore(dir, bytes.NewReader(snapshot.Bytes()), rc); !errors.Is(err, ErrNotEmpty) {
+			t.Errorf("expected %v. Got %v", ErrNotEmpty, err)
+		}
+
+		// A truncated snapshot is refused, and leaves nothing behind.
+		partial := t.TempDir() + "/partial"
+		if _, err := Restore(partial, bytes.NewReader(snapshot.Bytes()[:snapshot.Len()-1]), rc); err == nil {
+			t.Error("expected error restoring truncated snapshot")
+		}
+
+		if _, err := os.Stat(partial); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed. Got %v", partial, err)
+		}
+
+		// The segments being read outlive their removal.
+		r = l.Reader()
+		if err := l.Compact(20); err != nil {
+			t.Fatal(err)
+		}
+
+		var again bytes.Buffer
+		if _, err := io.Copy(&again, r); err != nil {
+			t.Fatalf("error reading log: %v", err)
+		}
+
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(again.Bytes(), snapshot.Bytes()) {
+			t.Errorf("expected removed segments to be read in full")
+		}
+
+		// Truncating the log while it is read fails the reader.
+		r = restored.Reader()
+		if err := restored.Truncate(14); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.Copy(io.Discard, r); !errors.Is(err, ErrTruncated) {
+			t.Errorf("expected %v. Got %v", ErrTruncated, err)
+		}
+
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Encryption", func(t *testing.T) {
+		dir := t.TempDir()
+
+		keys, err := keyring.OpenFile(filepath.Join(t.TempDir(), "keys"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := config
+		c.Segment.Keys = keys
+
+		l, err := New(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n, err := l.Reencrypt(); err != nil || n != 0 {
+			t.Errorf("expected no segments to reencrypt. Got %d (%v)", n, err)
+		}
+
+		current, err := keys.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Segments hold records [0, 2], [3, 5] and [6]. Only the sealed ones are
+		// rewritten.
+		if n, err := l.Reencrypt(); err != nil || n != 2 {
+			t.Errorf("expected %d segments reencrypted. Got %d (%v)", 2, n, err)
+		}
+
+		l.mu.RLock()
+		for _, seg := range l.segments[:len(l.segments)-1] {
+			if id := seg.KeyID(); id != current {
+				t.Errorf("expected segment %d under key %d. Got %d", seg.BaseOffset, current, id)
+			}
+		}
+		l.mu.RUnlock()
+
+		for off := uint64(0); off < 7; off++ {
+			rec, err := l.Read(off)
+			if err != nil {
+				t.Fatalf("error reading offset %d: %v", off, err)
+			}
+
+			if string(rec.Value) != fmt.Sprint(off) {
+				t.Errorf("expected value %d. Got %s", off, rec.Value)
+			}
+		}
+	})
+
+	t.Run("Tiering", func(t *testing.T) {
+		store, err := tier.NewDir(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+
+		c := config
+		c.Tiering = Tiering{Store: store, CacheSegments: 1}
+
+		l, err := New(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Segments hold records [0, 2], [3, 5] and [6]. Only the active segment
+		// is kept on local disk.
+		if err := l.offload(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := l.segmentCount(); n != 1 {
+			t.Errorf("expected %d local segment. Got %d", 1, n)
+		}
+
+		names, err := store.List("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(names) != "[0.meta 0.store 3.meta 3.store]" {
+			t.Errorf("expected two offloaded segments. Got %v", names)
+		}
+
+		l.Close()
+
+		// The offloaded segments are found again when the log is reopened.
+		l, err = New(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		if low := l.LowestOffset(); low != 0 {
+			t.Errorf("expected lowest offset of %d. Got %d", 0, low)
+		}
+
+		// Reading alternates between the offloaded segments, so each read
+		// evicts the other from the cache.
+		for _, off := range []uint64{0, 4, 1, 5, 6} {
+			rec, err := l.Read(off)
+			if err != nil {
+				t.Fatalf("error reading offset %d: %v", off, err)
+			}
+
+			if string(rec.Value) != fmt.Sprint(off) {
+				t.Errorf("expected value %d. Got %s", off, rec.Value)
+			}
+		}
+
+		var offsets []uint64
+		err = l.Range(2, func(rec *record.Record) bool {
+			offsets = append(offsets, rec.Offset)
+
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(offsets) != "[2 3 4 5 6]" {
+			t.Errorf("expected to range over [2 3 4 5 6]. Got %v", offsets)
+		}
+
+		// Deleting the first segment deletes its objects.
+		if err := l.Compact(4); err != nil {
+			t.Fatal(err)
+		}
+
+		if low := l.LowestOffset(); low != 3 {
+			t.Errorf("expected lowest offset of %d. Got %d", 3, low)
+		}
+
+		if _, err := l.Read(0); err != (ErrOutOfBounds{0}) {
+			t.Errorf("expected %v. Got %v", ErrOutOfBounds{0}, err)
+		}
+
+		if names, _ := store.List("0."); len(names) != 0 {
+			t.Errorf("expected objects to be deleted. Got %v", names)
+		}
+	})
+
+	t.Run("EncryptionTiered", func(t *testing.T) {
+		store, err := tier.NewDir(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := keyring.OpenFile(filepath.Join(t.TempDir(), "keys"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := config
+		c.Segment.Keys = keys
+		c.Tiering = Tiering{Store: store, CacheSegments: 1}
+
+		l, err := New(t.TempDir(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.offload(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		current, err := keys.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Both offloaded segments are rewritten, while the active segment is
+		// not.
+		if n, err := l.Reencrypt(); err != nil || n != 2 {
+			t.Errorf("expected %d segments reencrypted. Got %d (%v)", 2, n, err)
+		}
+
+		if n, err := l.Reencrypt(); err != nil || n != 0 {
+			t.Errorf("expected no segments to reencrypt. Got %d (%v)", n, err)
+		}
+
+		for off := uint64(0); off < 6; off++ {
+			r, _ := l.remoteFor(off)
+
+			var id uint32
+			err := l.withRemote(r, func(seg *segment.Segment) error {
+				id = seg.KeyID()
+
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if id != current {
+				t.Errorf("expected offset %d under key %d. Got %d", off, current, id)
+			}
+
+			rec, err := l.Read(off)
+			if err != nil {
+				t.Fatalf("error reading offset %d: %v", off, err)
+			}
+
+			if string(rec.Value) != fmt.Sprint(off) {
+				t.Errorf("expected value %d. Got %s", off, rec.Value)
+			}
+		}
+	})
+
+	t.Run("TieringRemoved", func(t *testing.T) {
+		dir, err := tier.NewDir(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var l *Log
+
+		// The first segment is deleted while it is being uploaded, which must
+		// not wait for the upload.
+		var once sync.Once
+		store := hookedStore{dir, func(string) {
+			once.Do(func() {
+				if err := l.Compact(4); err != nil {
+					t.Error(err)
+				}
+			})
+		}}
+
+		c := config
+		c.Tiering = Tiering{Store: store, CacheSegments: 1}
+
+		l, err = New(t.TempDir(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			l.Close()
+		})
+
+		for i := 0; i < 7; i++ {
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := l.offload(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		// Nothing is offloaded, since the uploads no longer begin the log.
+		if n := l.segmentCount(); n != 2 {
+			t.Errorf("expected %d local segments. Got %d", 2, n)
+		}
+
+		if names, _ := dir.List(""); len(names) != 0 {
+			t.Errorf("expected uploads to be deleted. Got %v", names)
+		}
+
+		if low := l.LowestOffset(); low != 3 {
+			t.Errorf("expected lowest offset of %d. Got %d", 3, low)
+		}
+
+		// The next run offloads the remaining sealed segment.
+		if err := l.offload(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := l.segmentCount(); n != 1 {
+			t.Errorf("expected %d local segment. Got %d", 1, n)
+		}
+
+		if rec, err := l.Read(3); err != nil || string(rec.Value) != "3" {
+			t.Errorf("expected value 3. Got %v (%v)", rec, err)
+		}
+	})
+}
+
+// hookedStore calls [hook] with the name of each object before it is put.
+type hookedStore struct {
+	tier.ObjectStore
+	hook func(name string)
+}
+
+func (h hookedStore) Put(name string, r io.Reader) error {
+	h.hook(name)
+
+	return h.ObjectStore.Put(name, r)
+}
+
+// BenchmarkLog measures reads and appends in isolation and together. In the
+// Mixed benchmark, a single producer appends for as long as the parallel
+// consumers are reading, and its throughput is reported alongside theirs.
+func BenchmarkLog(b *testing.B) {
+	c := Config{
+		Segment: segment.Config{MaxIndexBytes: 1 << 20, MaxStoreBytes: 1 << 24},
+	}
+
+	value := make([]byte, 256)
+
+	// Each benchmark begins with enough records for consumers to read.
+	const seeded = 1000
+
+	setup := func(b *testing.B) *Log {
+		l, err := New(b.TempDir(), c)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Cleanup(func() {
+			l.Close()
+		})
+
+		for i := 0; i < seeded; i++ {
+			if _, err := l.Append(&record.Record{Value: value}); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		return l
+	}
+
+	read := func(l *Log, b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := uint64(0); pb.Next(); i++ {
+				if _, err := l.Read(i % seeded); err != nil {
+					b.Error(err)
+
+					return
+				}
+			}
+		})
+	}
+
+	b.Run("Append", func(b *testing.B) {
+		l := setup(b)
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := l.Append(&record.Record{Value: value}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Read", func(b *testing.B) {
+		l := setup(b)
+		b.ResetTimer()
+
+		read(l, b)
+	})
+
+	b.Run("Mixed", func(b *testing.B) {
+		l := setup(b)
+
+		var (
+			appends atomic.Uint64
+			wg      sync.WaitGroup
+		)
+
+		done := make(chan struct{})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				if _, err := l.Append(&record.Record{Value: value}); err != nil {
+					b.Error(err)
+
+					return
+				}
+
+				appends.Add(1)
+			}
+		}()
+
+		b.ResetTimer()
+		read(l, b)
+		b.StopTimer()
+
+		close(done)
+		wg.Wait()
+
+		b.ReportMetric(float64(appends.Load())/b.Elapsed().Seconds(), "appends/s")
+	})
+}
+
+// segmentCount returns the number of segments in [l] under its lock.
+func (l *Log) segmentCount() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return len(l.segments)
+}
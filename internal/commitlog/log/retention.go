@@ -0,0 +1,70 @@
+package log
+
+import (
+	"time"
+)
+
+// Retention bounds the amount of data kept by a [Log]. Sealed segments are
+// deleted from the front of the log once they exceed either limit, while the
+// active segment is never deleted. A zero limit is not enforced.
+type Retention struct {
+	MaxAge   time.Duration // MaxAge is the age of a segment's newest record beyond which it is deleted.
+	MaxBytes uint64        // MaxBytes is the size of the log's stores beyond which segments are deleted.
+	Interval time.Duration // Interval is how often retention is enforced.
+}
+
+// retain deletes the oldest sealed segments which fall outside the log's
+// retention limits as of [now]. Segments are only ever deleted from the front
+// of the log so that it remains contiguous, so offloaded segments are deleted
+// before any on local disk. The caller must hold the lock.
+func (l *Log) retain(now time.Time) error {
+	var total uint64
+	for _, r := range l.remote {
+		total += r.Size
+	}
+
+	for _, seg := range l.segments {
+		total += seg.Size()
+	}
+
+	var r int
+	for ; r < len(l.remote); r++ {
+		if !l.Retention.exceeded(now, time.UnixMilli(l.remote[r].MaxTimestamp), total) {
+			break
+		}
+
+		total -= l.remote[r].Size
+	}
+
+	if err := l.removeRemote(r); err != nil {
+		return err
+	}
+
+	if len(l.remote) > 0 {
+		return nil
+	}
+
+	// The active segment is always the last segment, so it is never considered.
+	var n int
+	for ; n < len(l.segments)-1; n++ {
+		seg := l.segments[n]
+
+		if !l.Retention.exceeded(now, seg.MaxTimestamp(), total) {
+			break
+		}
+
+		total -= seg.Size()
+	}
+
+	return l.removePrefix(n)
+}
+
+// exceeded reports whether a segment whose newest record was written at
+// [newest] falls outside the retention limits as of [now], while the log holds
+// [total] bytes.
+func (r Retention) exceeded(now, newest time.Time, total uint64) bool {
+	expired := r.MaxAge > 0 && now.Sub(newest) > r.MaxAge
+	oversized := r.MaxBytes > 0 && total > r.MaxBytes
+
+	return expired || oversized
+}
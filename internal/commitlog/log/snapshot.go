@@ -0,0 +1,194 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/store"
+)
+
+var (
+	// ErrNotEmpty is returned when restoring a log into a directory which
+	// already holds files.
+	ErrNotEmpty = errors.New("directory is not empty")
+
+	// ErrTruncated is returned when reading the contents of a log which was
+	// truncated after reading began, as records yet to be read may be gone.
+	ErrTruncated = errors.New("log truncated while reading")
+)
+
+// Reader returns a reader of the raw contents of the log: the store of every
+// segment, without its header, concatenated in order of offset. Only records
+// appended before Reader is called are read. The contents may be restored into
+// a new log with [Restore], e.g. to take a backup or to bootstrap a replica.
+//
+// Segments already offloaded to the object store (see [Tiering]) are not read,
+// and encrypted records are read as is.
+//
+// The segments are pinned until the reader is closed, so those removed by
+// retention, compaction or offloading in the meantime are still read in full.
+// Truncating the log while it is read fails the reader with [ErrTruncated].
+func (l *Log) Reader() io.ReadCloser {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	readers := make([]io.Reader, len(l.segments))
+	for i, seg := range l.segments {
+		seg.Pin()
+		readers[i] = seg.Reader()
+	}
+
+	return &snapshot{
+		Reader:      io.MultiReader(readers...),
+		l:           l,
+		segments:    slices.Clone(l.segments),
+		truncations: l.truncations,
+	}
+}
+
+// snapshot reads the pinned segments of a log for [Log.Reader].
+type snapshot struct {
+	io.Reader
+
+	l           *Log
+	segments    []*segment.Segment
+	truncations uint64
+}
+
+// Read implements [io.Reader]. A truncated segment appears to end early, so
+// reaching the end of the log after it was truncated is reported as an error.
+func (s *snapshot) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+
+	s.l.mu.RLock()
+	defer s.l.mu.RUnlock()
+
+	if s.l.truncations != s.truncations {
+		return n, ErrTruncated
+	}
+
+	return n, err
+}
+
+// Close implements [io.Closer], unpinning the segments.
+func (s *snapshot) Close() error {
+	var err error
+	for _, seg := range s.segments {
+		if uerr := seg.Unpin(); err == nil {
+			err = uerr
+		}
+	}
+
+	s.segments = nil
+
+	return err
+}
+
+// Restore creates a log under [dir] from [r], the contents of a log as read
+// from [Log.Reader], and opens it with the configuration [c]. Records keep
+// their offsets, timestamps and keys, while segments and indexes are rebuilt
+// according to [c]. The restored log begins with the first record in [r].
+// Encrypted records are decrypted with [segment.Config.Keys], and encrypted
+// again under its current key. [dir] must be empty or not exist.
+//
+// If restoring fails, [dir] is removed.
+func Restore(dir string, r io.Reader, c Config) (*Log, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(files) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotEmpty, dir)
+	}
+
+	dec := store.NewDecoder(r)
+
+	records, err := nextBatch(dec, c.Segment.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing may run in the background until every record is restored.
+	restore := Config{Segment: c.Segment}
+	restore.Segment.MaxSegmentAge = 0
+	if len(records) > 0 {
+		restore.Segment.InitialOffset = records[0].Offset
+	}
+
+	l, err := New(dir, restore)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(records) > 0 && err == nil {
+		if err = l.appendAt(records); err == nil {
+			records, err = nextBatch(dec, c.Segment.Keys)
+		}
+	}
+
+	if err != nil {
+		l.Remove()
+
+		return nil, fmt.Errorf("restoring %s: %w", dir, err)
+	}
+
+	if err := l.Close(); err != nil {
+		return nil, err
+	}
+
+	return New(dir, c)
+}
+
+// nextBatch returns the records of the next batch read by [dec], or nil once
+// there are none left. Encrypted batches are decrypted with [keys].
+func nextBatch(dec *store.Decoder, keys keyring.Keyring) ([]*record.Record, error) {
+	b, err := dec.Next()
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return segment.DecodeBatch(b, keys)
+}
+
+// appendAt appends [records], a batch read from another log, at their original
+// offsets rather than the next offset of the log. Offsets skipped since the
+// previous batch (e.g. records removed by compaction) are left as a gap. A
+// batch too large for a segment of this log is appended one record at a time.
+func (l *Log) appendAt(records []*record.Record) error {
+	next := records[0].Offset
+	if next < l.activeSegment.NextOffset {
+		return fmt.Errorf("batch at offset %d is out of order", next)
+	}
+
+	// Nothing else appends to the log while it is restored, so the next offset
+	// may simply be moved.
+	l.activeSegment.NextOffset = next
+
+	_, err := l.AppendBatch(records)
+	if err != ErrBatchTooLarge {
+		return err
+	}
+
+	for _, rec := range records {
+		if _, err := l.Append(rec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,531 @@
+package log
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/tier"
+)
+
+const (
+	// cacheDir is the directory under the log's directory in which segments
+	// fetched from the object store are kept.
+	cacheDir = ".tier"
+
+	// metaExt is the extension of the object describing an offloaded segment.
+	metaExt = ".meta"
+)
+
+// Tiering configures the offloading of sealed segments to an object store.
+// Once offloaded, a segment is removed from local disk, and is fetched back
+// when one of its records is read. The active segment is never offloaded.
+//
+// Retention applies to offloaded segments like any other, deleting them from
+// the object store, but compaction only rewrites segments on local disk.
+type Tiering struct {
+	Store         tier.ObjectStore // Store receives offloaded segments, or nil to keep every segment on local disk.
+	HotSegments   int              // HotSegments is the number of the newest sealed segments kept on local disk.
+	CacheSegments int              // CacheSegments is the number of fetched segments kept on local disk.
+	Interval      time.Duration    // Interval is how often sealed segments are offloaded.
+}
+
+// remoteSegment describes a segment offloaded to the object store. Only the
+// segment's store is uploaded, as "<base>.store", and its indexes are rebuilt
+// when it is fetched. The description is uploaded last as "<base>.meta", so
+// its presence marks the upload as complete.
+type remoteSegment struct {
+	BaseOffset   uint64 `json:"baseOffset"`
+	NextOffset   uint64 `json:"nextOffset"`
+	Size         uint64 `json:"size"`
+	MaxTimestamp int64  `json:"maxTimestamp"` // MaxTimestamp is in milliseconds.
+	KeyID        uint32 `json:"keyID"`        // KeyID is the key encrypting the segment, or 0.
+}
+
+// name returns the name of the object holding the file of the segment with the
+// extension [ext].
+func (r remoteSegment) name(ext string) string {
+	return fmt.Sprintf("%d%s", r.BaseOffset, ext)
+}
+
+// loadRemote finds the segments previously offloaded to the object store. A
+// segment still on local disk was not removed after its upload, so the local
+// copy is used instead. Any segments left in the cache are discarded.
+func (l *Log) loadRemote() error {
+	if err := os.RemoveAll(filepath.Join(l.Dir, cacheDir)); err != nil {
+		return err
+	}
+
+	if l.Tiering.Store == nil {
+		return nil
+	}
+
+	names, err := l.Tiering.Store.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if filepath.Ext(name) != metaExt {
+			continue
+		}
+
+		obj, err := l.Tiering.Store.Get(name)
+		if err != nil {
+			return err
+		}
+
+		var r remoteSegment
+		err = json.NewDecoder(obj).Decode(&r)
+		obj.Close()
+
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+
+		if r.BaseOffset < l.segments[0].BaseOffset {
+			l.remote = append(l.remote, r)
+		}
+	}
+
+	// Objects are sorted by name rather than by offset.
+	slices.SortFunc(l.remote, func(a, b remoteSegment) int {
+		return cmp.Compare(a.BaseOffset, b.BaseOffset)
+	})
+
+	return nil
+}
+
+// offload uploads every sealed segment but the newest [Tiering.HotSegments] to
+// the object store, and removes them from local disk.
+//
+// Sealed segments do not change, so they are pinned and uploaded without the
+// lock, which is only taken to swap them for their uploads. Offloaded segments
+// must precede the local ones, so only those still at the start of the log are
+// swapped, and the uploads of any removed, replaced or truncated in the
+// meantime are deleted.
+func (l *Log) offload(time.Time) (err error) {
+	l.mu.RLock()
+	n := max(len(l.segments)-1-l.Tiering.HotSegments, 0)
+	segments := slices.Clone(l.segments[:n])
+	truncations := l.truncations
+
+	for _, seg := range segments {
+		seg.Pin()
+	}
+	l.mu.RUnlock()
+
+	defer func() {
+		for _, seg := range segments {
+			if uerr := seg.Unpin(); err == nil {
+				err = uerr
+			}
+		}
+	}()
+
+	// Segments uploaded before a failure are still offloaded.
+	var uploaded []remoteSegment
+	for _, seg := range segments {
+		var r remoteSegment
+		if r, err = l.upload(seg); err != nil {
+			break
+		}
+
+		uploaded = append(uploaded, r)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k := 0
+	if l.truncations == truncations {
+		for k < len(uploaded) && k < len(l.segments)-1 && l.segments[k] == segments[k] {
+			k++
+		}
+	}
+
+	for _, r := range uploaded[k:] {
+		if derr := l.deleteRemote(r); err == nil {
+			err = derr
+		}
+	}
+
+	for i, seg := range l.segments[:k] {
+		if rerr := seg.Remove(); rerr != nil {
+			// Keep the segments which have not been removed. Their uploads
+			// are ignored when the log is next opened.
+			clear(l.segments[:i])
+			l.segments = l.segments[i:]
+
+			return rerr
+		}
+
+		l.remote = append(l.remote, uploaded[i])
+	}
+
+	clear(l.segments[:k])
+	l.segments = l.segments[k:]
+
+	return err
+}
+
+// upload copies the store of the sealed segment [seg] to the object store,
+// leaving the segment intact.
+func (l *Log) upload(seg *segment.Segment) (remoteSegment, error) {
+	r := remoteSegment{
+		BaseOffset:   seg.BaseOffset,
+		NextOffset:   seg.NextOffset,
+		Size:         seg.Size(),
+		MaxTimestamp: seg.MaxTimestamp().UnixMilli(),
+		KeyID:        seg.KeyID(),
+	}
+
+	f, err := os.Open(seg.Files()[0])
+	if err != nil {
+		return r, err
+	}
+
+	err = l.Tiering.Store.Put(r.name(".store"), f)
+	f.Close()
+
+	if err != nil {
+		return r, err
+	}
+
+	meta, err := json.Marshal(r)
+	if err != nil {
+		return r, err
+	}
+
+	return r, l.Tiering.Store.Put(r.name(metaExt), bytes.NewReader(meta))
+}
+
+// deleteRemote deletes the objects of the offloaded segment [r]. Its
+// description is deleted first, so a failure part way through leaves at worst
+// an orphaned store object.
+func (l *Log) deleteRemote(r remoteSegment) error {
+	for _, name := range []string{r.name(metaExt), r.name(".store")} {
+		if err := l.Tiering.Store.Delete(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remoteFor returns the offloaded segment holding [off]. The caller must hold
+// the lock.
+func (l *Log) remoteFor(off uint64) (remoteSegment, bool) {
+	i := sort.Search(len(l.remote), func(i int) bool {
+		return l.remote[i].BaseOffset > off
+	})
+
+	if i == 0 || off >= l.remote[i-1].NextOffset {
+		return remoteSegment{}, false
+	}
+
+	return l.remote[i-1], true
+}
+
+// fetch returns the offloaded segment [r], downloading it into the cache if it
+// is not already there. The least recently used segment is evicted to make
+// room for it. The caller must hold [cmu], and may only use the segment until
+// releasing it.
+func (l *Log) fetch(r remoteSegment) (*segment.Segment, error) {
+	for i, seg := range l.cache {
+		if seg.BaseOffset == r.BaseOffset {
+			l.cache = append(slices.Delete(l.cache, i, i+1), seg)
+
+			return seg, nil
+		}
+	}
+
+	dir := filepath.Join(l.Dir, cacheDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := download(l.Tiering.Store, r.name(".store"), filepath.Join(dir, r.name(".store"))); err != nil {
+		return nil, err
+	}
+
+	// The indexes are rebuilt from the store, which was sealed before it was
+	// offloaded.
+	seg, err := segment.Open(dir, r.BaseOffset, l.Config.Segment, segment.Sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.cache) >= l.Tiering.CacheSegments {
+		if err := l.cache[0].Remove(); err != nil {
+			seg.Remove()
+
+			return nil, err
+		}
+
+		l.cache = slices.Delete(l.cache, 0, 1)
+	}
+
+	l.cache = append(l.cache, seg)
+
+	return seg, nil
+}
+
+// download copies the object [name] from [store] to the file at [path].
+func download(store tier.ObjectStore, name, path string) error {
+	obj, err := store.Get(name)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, obj); err != nil {
+		f.Close()
+		os.Remove(path)
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// readRemote reads the record at [off] from an offloaded segment. The caller
+// must hold the lock.
+//
+// NOTE: Reads from offloaded segments are serialized, so that a segment cannot
+// be evicted from the cache while it is read.
+func (l *Log) readRemote(off uint64) (*record.Record, error) {
+	r, ok := l.remoteFor(off)
+	if !ok {
+		return nil, ErrOutOfBounds{off}
+	}
+
+	var rec *record.Record
+	err := l.withRemote(r, func(seg *segment.Segment) (err error) {
+		rec, err = seg.Read(off)
+
+		return err
+	})
+	if err == io.EOF {
+		return nil, ErrOutOfBounds{off}
+	}
+
+	return rec, err
+}
+
+// rangeRemote is [Log.Range] over the offloaded segments, reporting whether
+// [fn] asked for more records. The caller must hold the lock.
+func (l *Log) rangeRemote(from uint64, fn func(*record.Record) bool) (bool, error) {
+	more := true
+	for _, r := range l.remote {
+		if r.NextOffset <= from {
+			continue
+		}
+
+		err := l.withRemote(r, func(seg *segment.Segment) error {
+			return seg.Scan(max(from, seg.BaseOffset), func(rec *record.Record) bool {
+				more = fn(rec)
+
+				return more
+			})
+		})
+		if err != nil || !more {
+			return more, err
+		}
+	}
+
+	return more, nil
+}
+
+// offsetForTimeRemote is [Log.OffsetForTime] over the offloaded segments. The
+// caller must hold the lock.
+func (l *Log) offsetForTimeRemote(t time.Time) (uint64, bool, error) {
+	for _, r := range l.remote {
+		// The segment need not be fetched if every record is earlier than [t].
+		if r.MaxTimestamp < t.UnixMilli() {
+			continue
+		}
+
+		var (
+			off uint64
+			ok  bool
+		)
+		err := l.withRemote(r, func(seg *segment.Segment) (err error) {
+			off, ok, err = seg.OffsetForTime(t)
+
+			return err
+		})
+		if err != nil || ok {
+			return off, ok, err
+		}
+	}
+
+	return 0, false, nil
+}
+
+// withRemote calls [fn] with the offloaded segment [r], fetching it if needed.
+func (l *Log) withRemote(r remoteSegment, fn func(*segment.Segment) error) error {
+	l.cmu.Lock()
+	defer l.cmu.Unlock()
+
+	seg, err := l.fetch(r)
+	if err != nil {
+		return err
+	}
+
+	return fn(seg)
+}
+
+// reencryptRemote rewrites the offloaded segment [r] under the current key,
+// and uploads it in place of the original, returning its new description. The
+// segment is downloaded into a directory of its own rather than the cache, so
+// neither lock is needed. The description is uploaded last, so a failure part
+// way through leaves the original description, which is only wrong about the
+// key.
+//
+// The segment may be deleted by retention in the meantime, so the caller must
+// check it is still offloaded before adopting the new description, and delete
+// the upload otherwise (see [Log.replaceRemote]).
+func (l *Log) reencryptRemote(r remoteSegment) (remoteSegment, error) {
+	dir := filepath.Join(l.Dir, cacheDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return r, err
+	}
+
+	// The directory is within the cache, so it is removed along with the
+	// cache if the log is closed before it is.
+	tmp, err := os.MkdirTemp(dir, segment.CleanPrefix)
+	if err != nil {
+		return r, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := download(l.Tiering.Store, r.name(".store"), filepath.Join(tmp, r.name(".store"))); err != nil {
+		return r, err
+	}
+
+	seg, err := segment.Open(tmp, r.BaseOffset, l.Config.Segment, segment.Sealed)
+	if err != nil {
+		return r, err
+	}
+
+	reencrypted, _, err := seg.Clean(func(*record.Record) bool { return true })
+	if err != nil {
+		seg.Close()
+
+		return r, err
+	}
+	defer reencrypted.Close()
+
+	return l.upload(reencrypted)
+}
+
+// replaceRemote adopts the descriptions of the offloaded segments [uploaded] by
+// [Log.reencryptRemote], returning the number adopted. The upload of a segment
+// deleted in the meantime is deleted in turn, and any copy of an adopted
+// segment in the cache is evicted, since it is still encrypted under the key
+// which is to be retired. The caller must hold the lock.
+func (l *Log) replaceRemote(uploaded []remoteSegment) (int, error) {
+	l.cmu.Lock()
+	defer l.cmu.Unlock()
+
+	var n int
+	for _, r := range uploaded {
+		i := slices.IndexFunc(l.remote, func(orig remoteSegment) bool {
+			return orig.BaseOffset == r.BaseOffset
+		})
+		if i < 0 {
+			if err := l.deleteRemote(r); err != nil {
+				return n, err
+			}
+
+			continue
+		}
+
+		l.remote[i] = r
+		n++
+
+		k := slices.IndexFunc(l.cache, func(seg *segment.Segment) bool {
+			return seg.BaseOffset == r.BaseOffset
+		})
+		if k < 0 {
+			continue
+		}
+
+		err := l.cache[k].Remove()
+		l.cache = slices.Delete(l.cache, k, k+1)
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// removeRemote deletes the first [n] offloaded segments from the object store
+// (see [Log.deleteRemote]). The caller must hold the lock.
+func (l *Log) removeRemote(n int) error {
+	l.cmu.Lock()
+	defer l.cmu.Unlock()
+
+	for i, r := range l.remote[:n] {
+		if err := l.deleteRemote(r); err != nil {
+			// Keep the segments which have not been removed.
+			l.remote = l.remote[i:]
+
+			return err
+		}
+
+		k := slices.IndexFunc(l.cache, func(seg *segment.Segment) bool {
+			return seg.BaseOffset == r.BaseOffset
+		})
+		if k < 0 {
+			continue
+		}
+
+		err := l.cache[k].Remove()
+		l.cache = slices.Delete(l.cache, k, k+1)
+
+		if err != nil {
+			l.remote = l.remote[i+1:]
+
+			return err
+		}
+	}
+
+	l.remote = l.remote[n:]
+
+	return nil
+}
+
+// closeCache closes every segment in the cache. Their files are removed along
+// with the cache when the log is next opened.
+func (l *Log) closeCache() error {
+	l.cmu.Lock()
+	defer l.cmu.Unlock()
+
+	for _, seg := range l.cache {
+		if err := seg.Close(); err != nil {
+			return err
+		}
+	}
+
+	l.cache = nil
+
+	return nil
+}
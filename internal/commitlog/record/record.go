@@ -0,0 +1,31 @@
+// package record exposes a data type representing an entry in the commit log.
+// This serves as the input type to a segment. Route handlers should embed this
+// type in their respective requests.
+//
+// NOTE: This will almost certainly lead to packages which are tightly coupled
+// with this type, but that can be addressed later.
+package record
+
+import (
+	"time"
+)
+
+// Record is an entry in a commit log. A producer may supply the [Timestamp] of
+// a record, otherwise the time it is appended to the log is used.
+//
+// A record with a [Key] supersedes every earlier record with the same key once
+// the log is compacted. A keyed record with a nil [Value] is a tombstone,
+// marking the key as deleted. [Headers] carry arbitrary metadata alongside the
+// value and are never interpreted by the log.
+type Record struct {
+	Key       []byte            `json:"key,omitempty"`
+	Value     []byte            `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Offset    uint64            `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// IsTombstone reports whether the record marks its key as deleted.
+func (r Record) IsTombstone() bool {
+	return r.Key != nil && r.Value == nil
+}
@@ -0,0 +1,173 @@
+package segment
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+// baselineLenWidth is the width of the length preceding each record in a
+// baseline store.
+const baselineLenWidth = 8
+
+// Migrate opens the segment at [baseOffset] under [dir] like [Open], first
+// rewriting it if its files are in the baseline layout, which predates
+// checksums, batches and headers. A baseline store holds each record as its
+// length followed by the record encoded with version 0 of the record schema,
+// and a baseline index is a bare array of entries. The records of a baseline
+// segment have dense offsets from [baseOffset], which they keep.
+//
+// The copy is written into a temporary directory, and its indexes are moved
+// into place before its store, so the original store is only ever replaced
+// whole. A store which already begins with a header was migrated before a
+// crash, and is simply opened, so Migrate may be repeated.
+//
+// A record cut short at the end of the store is left out of the copy and
+// reported in [Segment.Recovery], unless the segment is [Sealed], in which case
+// [ErrCorrupt] is returned instead. A record which cannot be decoded is always
+// reported as [ErrCorrupt]. Either way, the store is left as it is.
+func Migrate(dir string, baseOffset uint64, c Config, mode Flag) (*Segment, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".store"))
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := header.Read(f, header.Store); !errors.Is(err, header.ErrMagic) {
+		return Open(dir, baseOffset, c, mode)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := recordCodec(0)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		records []*record.Record
+		pos     uint64
+	)
+
+	r := bufio.NewReader(f)
+	for {
+		data, err := readBaseline(r)
+		if err == io.EOF {
+			break
+		}
+
+		if err == io.ErrUnexpectedEOF && mode&Sealed == 0 {
+			break
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrCorrupt{baseOffset, pos, errors.New("truncated record")}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rec, _, err := decodeRecord(codec, data)
+		if err != nil {
+			return nil, ErrCorrupt{baseOffset, pos, err}
+		}
+
+		// Baseline records were written with 32-bit offsets, so the offset is
+		// taken from the record's position in the segment instead.
+		rec.Offset = baseOffset + uint64(len(records))
+		records = append(records, rec)
+		pos += baselineLenWidth + uint64(len(data))
+	}
+
+	tmp, err := os.MkdirTemp(dir, CleanPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// The best guess for the creation time of a baseline store is when it was
+	// last written to.
+	migrated, err := open(tmp, baseOffset, info.ModTime(), c, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 {
+		if _, err := migrated.AppendBatch(records); err != nil {
+			migrated.Close()
+
+			return nil, err
+		}
+	}
+
+	if err := migrated.Close(); err != nil {
+		return nil, err
+	}
+
+	names := []string{
+		fmt.Sprintf("%d%s", baseOffset, ".index"),
+		fmt.Sprintf("%d%s", baseOffset, ".timeindex"),
+		fmt.Sprintf("%d%s", baseOffset, ".store"),
+	}
+
+	// A baseline segment has no time index.
+	for _, name := range names[:2] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(tmp, name), filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := Open(dir, baseOffset, c, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Recovery.Bytes += uint64(info.Size()) - pos
+
+	return s, nil
+}
+
+// readBaseline reads the next record of a baseline store from [r]. [io.EOF] is
+// returned once the store ends between records, and [io.ErrUnexpectedEOF] if
+// it ends part way through one.
+func readBaseline(r io.Reader) ([]byte, error) {
+	var length [baselineLenWidth]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	// Like [store.Decoder], the buffer grows as the record is read rather than
+	// trusting the length up front.
+	n := enc.Uint64(length[:])
+
+	b, err := io.ReadAll(io.LimitReader(r, int64(min(n, math.MaxInt64))))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(b)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return b, nil
+}
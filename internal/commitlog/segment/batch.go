@@ -0,0 +1,393 @@
+package segment
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/linkedin/goavro"
+
+	"github.com/beautifultovarisch/dlog/internal/schema"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+// Codec identifies the compression applied to the records of a batch.
+type Codec uint8
+
+const (
+	CodecNone  Codec = iota // CodecNone stores records uncompressed.
+	CodecGzip               // CodecGzip compresses records with gzip.
+	CodecFlate              // CodecFlate compresses records with DEFLATE.
+)
+
+const (
+	codecMask     = 0x07 // The bits of the attributes byte holding the codec.
+	versionMask   = 0x38 // The bits of the attributes byte holding the schema version.
+	versionShift  = 3    // The position of the schema version in the attributes byte.
+	wideMask      = 0x40 // The bit of the attributes byte set by 64-bit headers.
+	encryptedMask = 0x80 // The bit of the attributes byte set by encrypted batches.
+	schemaVersion = 3    // The version of the record schema used to write batches.
+
+	attrWidth   = 1                                  // The width of the attributes byte
+	baseWidth   = 8                                  // The width of the batch's absolute base offset
+	countWidth  = 4                                  // The width of the batch's record count
+	headerWidth = attrWidth + baseWidth + countWidth // The total width of a batch header
+	keyWidth    = 4                                  // The width of the key ID of an encrypted batch
+
+	// Batches written before offsets were 64-bit hold a 32-bit base relative to
+	// the segment's base offset.
+	legacyBaseWidth   = 4
+	legacyHeaderWidth = attrWidth + legacyBaseWidth + countWidth
+)
+
+var (
+	enc = binary.BigEndian
+
+	// recordSchemas holds every version of the record schema, indexed by the
+	// version recorded in a batch's attributes. Batches written before schemas
+	// were versioned have version 0. Every change to the schema written must
+	// add a version here.
+	recordSchemas = [schemaVersion + 1]schema.CODEC{
+		schema.RECORD_V0,
+		schema.RECORD_V1,
+		schema.RECORD_V2,
+		schema.RECORD,
+	}
+
+	// errBatch is returned when a batch cannot be decoded.
+	errBatch = errors.New("malformed batch")
+)
+
+// A batch is the unit written to the store. Records are Avro encoded, joined
+// and compressed together with the codec recorded in the header:
+//
+//	0      1      9       13
+//	[attrs][base ][count ][compressed records...]
+//
+// [base] is the absolute offset of the first record, and the records occupy
+// [count] contiguous offsets from there. The attributes hold the codec and the
+// version of the schema used to encode the records:
+//
+//	7           6      5         3       0
+//	[encrypted][wide ][version ][codec ]
+//
+// An encrypted batch follows its header with the ID of the key encrypting it
+// (see [keyring.Keyring]), and its compressed records are sealed with AES-GCM,
+// which also authenticates the header:
+//
+//	0      1      9       13     17
+//	[attrs][base ][count ][key  ][nonce][sealed records...]
+//
+// The wide bit distinguishes these headers from legacy ones, whose [base] is
+// 32 bits wide and relative to the segment's base offset:
+//
+//	0      1      5       9
+//	[attrs][base ][count ][compressed records...]
+//
+// Legacy batches remain readable, and are rewritten by [Segment.Clean].
+type batch struct {
+	codec   Codec
+	version uint8
+	base    uint64 // base is always absolute once parsed.
+	count   uint32
+	keyID   uint32 // keyID is 0 unless the batch is encrypted.
+}
+
+// parseBatch decodes the header of the batch [b] belonging to the segment with
+// base offset [segBase], returning the header along with the (still
+// compressed and possibly encrypted) records that follow it.
+func parseBatch(b []byte, segBase uint64) (batch, []byte, error) {
+	if len(b) < attrWidth {
+		return batch{}, nil, errBatch
+	}
+
+	h := batch{
+		codec:   Codec(b[0] & codecMask),
+		version: (b[0] & versionMask) >> versionShift,
+	}
+
+	width := headerWidth
+	if b[0]&wideMask != 0 {
+		if len(b) < headerWidth {
+			return batch{}, nil, errBatch
+		}
+
+		h.base = enc.Uint64(b[attrWidth:])
+		h.count = enc.Uint32(b[attrWidth+baseWidth:])
+
+		if b[0]&encryptedMask != 0 {
+			if len(b) < headerWidth+keyWidth {
+				return batch{}, nil, errBatch
+			}
+
+			h.keyID = enc.Uint32(b[headerWidth:])
+			width += keyWidth
+
+			if h.keyID == 0 {
+				return batch{}, nil, errBatch
+			}
+		}
+	} else {
+		if len(b) < legacyHeaderWidth {
+			return batch{}, nil, errBatch
+		}
+
+		h.base = segBase + uint64(enc.Uint32(b[attrWidth:]))
+		h.count = enc.Uint32(b[attrWidth+legacyBaseWidth:])
+		width = legacyHeaderWidth
+	}
+
+	if h.codec > CodecFlate || h.version > schemaVersion || h.count == 0 {
+		return batch{}, nil, errBatch
+	}
+
+	// The offsets of a batch must not wrap around.
+	if h.base+uint64(h.count) < h.base {
+		return batch{}, nil, errBatch
+	}
+
+	return h, b[width:], nil
+}
+
+// next returns the offset following the last record in the batch.
+func (h batch) next() uint64 {
+	return h.base + uint64(h.count)
+}
+
+// encodeBatch compresses the Avro encoded [records] as a single batch with the
+// header [h]. If [h] has a key ID, the records are then encrypted with [key].
+func encodeBatch(h batch, records [][]byte, key []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	attrs := byte(h.codec)&codecMask | h.version<<versionShift&versionMask | wideMask
+	if h.keyID != 0 {
+		attrs |= encryptedMask
+	}
+
+	buf.WriteByte(attrs)
+	binary.Write(&buf, enc, h.base)
+	binary.Write(&buf, enc, h.count)
+
+	if h.keyID != 0 {
+		binary.Write(&buf, enc, h.keyID)
+	}
+
+	width := buf.Len()
+
+	var w io.WriteCloser
+	switch h.codec {
+	case CodecNone:
+		w = nopCloser{&buf}
+	case CodecGzip:
+		w = gzip.NewWriter(&buf)
+	case CodecFlate:
+		// This only fails given an invalid compression level.
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("unknown codec: %d", h.codec)
+	}
+
+	for _, r := range records {
+		if _, err := w.Write(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// Closing flushes any remaining compressed bytes into [buf].
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	b := buf.Bytes()
+	if h.keyID == 0 {
+		return b, nil
+	}
+
+	sealed, err := seal(key, b[width:], b[:width])
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b[:width:width], sealed...), nil
+}
+
+// decodeBody returns the Avro encoded records of the batch [b], whose header
+// [h] and body [body] were returned by [parseBatch]. An encrypted body is
+// decrypted with the key from [keys] before it is decompressed.
+func decodeBody(h batch, b, body []byte, keys keyring.Keyring) ([]byte, error) {
+	if h.keyID != 0 {
+		key, err := lookupKey(keys, h.keyID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The header is everything preceding the body.
+		if body, err = unseal(key, body, b[:len(b)-len(body)]); err != nil {
+			return nil, err
+		}
+	}
+
+	return decompress(h.codec, body)
+}
+
+// decompress returns the Avro encoded records contained in [body], which was
+// compressed with [codec].
+func decompress(codec Codec, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch codec {
+	case CodecNone:
+		return body, nil
+	case CodecGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+
+		r = gz
+	case CodecFlate:
+		fl := flate.NewReader(bytes.NewReader(body))
+		defer fl.Close()
+
+		r = fl
+	default:
+		return nil, fmt.Errorf("unknown codec: %d", codec)
+	}
+
+	return io.ReadAll(r)
+}
+
+// recordCodec returns the codec for the record schema [version].
+func recordCodec(version uint8) (*goavro.Codec, error) {
+	return schema.GetCodec(recordSchemas[version])
+}
+
+// encodeRecord encodes [rec] in binary with the codec [c], assigning it the
+// offset [off].
+func encodeRecord(c *goavro.Codec, rec *record.Record, off uint64) ([]byte, error) {
+	headers := make(map[string]interface{}, len(rec.Headers))
+	for k, v := range rec.Headers {
+		headers[k] = v
+	}
+
+	// Avro requires this type.
+	r := map[string]interface{}{
+		"key":       nullable(rec.Key),
+		"value":     nullable(rec.Value),
+		"offset":    int64(off),
+		"timestamp": millis(rec.Timestamp),
+		"headers":   headers,
+	}
+
+	return c.BinaryFromNative(nil, r)
+}
+
+// nullable converts [b] to the native form of an Avro union of null and bytes,
+// in which a nil slice is encoded as null.
+func nullable(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+
+	return goavro.Union("bytes", b)
+}
+
+// fromNullable is the inverse of [nullable]. It also accepts plain bytes, as
+// values were not nullable before version 2 of the record schema.
+func fromNullable(v interface{}) []byte {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		b, _ := v["bytes"].([]byte)
+
+		return b
+	case []byte:
+		return v
+	}
+
+	return nil
+}
+
+// decodeRecord decodes the first record in [data] with the codec [c] and
+// returns it along with the bytes following it. [c] may be the codec for any
+// version of the record schema, as the record is resolved to the current one.
+func decodeRecord(c *goavro.Codec, data []byte) (*record.Record, []byte, error) {
+	rec, rest, err := c.NativeFromBinary(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// I don't actually know if this assertion will ever fail.
+	if m, ok := rec.(map[string]interface{}); ok {
+		m, err := schema.Resolve(m, schema.RECORD)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, ok := m["key"]
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to retrieve 'key' from record")
+		}
+
+		value, ok := m["value"]
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to retrieve 'value' from record")
+		}
+
+		offset, ok := m["offset"]
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to retrieve 'offset' from record")
+		}
+
+		timestamp, ok := m["timestamp"]
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to retrieve 'timestamp' from record")
+		}
+
+		headers, ok := m["headers"]
+		if !ok {
+			return nil, nil, fmt.Errorf("unable to retrieve 'headers' from record")
+		}
+
+		// Let it panic. See if I care...
+		return &record.Record{
+			Key:       fromNullable(key),
+			Value:     fromNullable(value),
+			Headers:   fromMap(headers.(map[string]interface{})),
+			Offset:    uint64(offset.(int64)),
+			Timestamp: time.UnixMilli(timestamp.(int64)),
+		}, rest, nil
+	} else {
+		return nil, nil, fmt.Errorf("invalid type. %v is not a map", rec)
+	}
+}
+
+// fromMap converts the native form of an Avro map of strings to a Go map. An
+// empty map is converted to nil.
+func fromMap(m map[string]interface{}) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(m))
+	for k, v := range m {
+		headers[k] = v.(string)
+	}
+
+	return headers
+}
+
+// nopCloser adds a no-op Close method to an [io.Writer].
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,162 @@
+package segment
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+// CleanPrefix begins the name of the temporary directory in which a segment is
+// rewritten by [Segment.Clean]. Such a directory left behind by a crash can be
+// removed.
+const CleanPrefix = ".clean"
+
+// Clean rewrites the segment, keeping only the records for which [keep] returns
+// true. Retained records keep their original offsets, so the offsets of a
+// cleaned segment may have gaps. The segment is closed, and the cleaned segment
+// replacing it is returned along with the number of records removed. Clean is
+// [Segment.Rewrite] followed by [Rewritten.Replace].
+//
+// Cleaning a segment opened with [NewLegacy] gives its files headers, and the
+// cleaned segment is encrypted under the current key of [Config.Keys].
+//
+// NOTE: Only sealed segments should be cleaned, as the segment is reopened
+// with its [NextOffset] following the last record kept.
+func (s *Segment) Clean(keep func(*record.Record) bool) (*Segment, uint64, error) {
+	r, err := s.Rewrite(keep)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cleaned, err := r.Replace()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return cleaned, r.Removed, nil
+}
+
+// Rewritten is a copy of a segment written by [Segment.Rewrite], which is yet
+// to replace it.
+type Rewritten struct {
+	Removed uint64 // Removed is the number of records left out of the copy.
+
+	orig, seg *Segment
+	tmp       string
+}
+
+// Rewrite writes a copy of the segment into a temporary directory under its
+// own, keeping only the records for which [keep] returns true. The segment
+// itself is only read, so Rewrite may run concurrently with its readers. The
+// copy must then either replace the segment or be discarded.
+func (s *Segment) Rewrite(keep func(*record.Record) bool) (*Rewritten, error) {
+	tmp, err := os.MkdirTemp(filepath.Dir(s.store.Name()), CleanPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cleaned segment is as old as the one it replaces.
+	cleaned, err := open(tmp, s.BaseOffset, s.Created(), s.Config, 0)
+	if err != nil {
+		os.RemoveAll(tmp)
+
+		return nil, err
+	}
+
+	// Each run of records with contiguous offsets is written as a batch, since
+	// the offsets of a batch must be contiguous.
+	var (
+		run     []*record.Record
+		removed uint64
+		werr    error
+	)
+	flush := func() {
+		if len(run) == 0 || werr != nil {
+			return
+		}
+
+		cleaned.NextOffset = run[0].Offset
+		_, werr = cleaned.AppendBatch(run)
+		run = run[:0]
+	}
+
+	err = s.Scan(s.BaseOffset, func(rec *record.Record) bool {
+		if !keep(rec) {
+			removed++
+			flush()
+
+			return werr == nil
+		}
+
+		if n := len(run); n > 0 && run[n-1].Offset+1 != rec.Offset {
+			flush()
+		}
+
+		run = append(run, rec)
+
+		return werr == nil
+	})
+	flush()
+
+	if err == nil {
+		err = werr
+	}
+
+	if err != nil {
+		cleaned.Close()
+		os.RemoveAll(tmp)
+
+		return nil, err
+	}
+
+	if err := cleaned.Close(); err != nil {
+		os.RemoveAll(tmp)
+
+		return nil, err
+	}
+
+	return &Rewritten{removed, s, cleaned, tmp}, nil
+}
+
+// Original returns the segment which was rewritten.
+func (r *Rewritten) Original() *Segment {
+	return r.orig
+}
+
+// Replace closes the original segment and swaps the copy into place,
+// returning it reopened. Its indexes are removed before the store is replaced,
+// so a crash part way through leaves either the old or the new store, and an
+// index missing from either is rebuilt when the segment is next opened.
+func (r *Rewritten) Replace() (*Segment, error) {
+	defer os.RemoveAll(r.tmp)
+
+	s := r.orig
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{s.index.Name(), s.timeIndex.Name()} {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replace the store, then its indexes.
+	for _, f := range []struct{ from, to string }{
+		{r.seg.store.Name(), s.store.Name()},
+		{r.seg.index.Name(), s.index.Name()},
+		{r.seg.timeIndex.Name(), s.timeIndex.Name()},
+	} {
+		if err := os.Rename(f.from, f.to); err != nil {
+			return nil, err
+		}
+	}
+
+	return Open(filepath.Dir(s.store.Name()), s.BaseOffset, s.Config, Sealed)
+}
+
+// Discard removes the copy, leaving the original segment as it was.
+func (r *Rewritten) Discard() error {
+	return os.RemoveAll(r.tmp)
+}
@@ -0,0 +1,71 @@
+package segment
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
+)
+
+// errSealed is returned when an encrypted batch fails authentication, i.e. it
+// was modified or encrypted under a different key with the same ID.
+var errSealed = errors.New("batch failed authentication")
+
+// lookupKey returns the key [id] from [keys].
+func lookupKey(keys keyring.Keyring, id uint32) ([]byte, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("%w: %d (no keyring configured)", keyring.ErrUnknownKey, id)
+	}
+
+	return keys.Key(id)
+}
+
+// newGCM returns AES-GCM using [key].
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts [plaintext] with AES-GCM under [key], authenticating [aad]
+// along with it. The random nonce precedes the ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// unseal reverses [seal].
+func unseal(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errSealed
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errSealed
+	}
+
+	return plaintext, nil
+}
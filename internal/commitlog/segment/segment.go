@@ -2,13 +2,19 @@
 package segment
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/beautifultovarisch/dlog/internal/schema"
-
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
 	"github.com/beautifultovarisch/dlog/internal/commitlog/index"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
 	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
 	"github.com/beautifultovarisch/dlog/internal/commitlog/store"
 )
@@ -18,29 +24,140 @@ const (
 	fileMode = 0644
 )
 
+// ErrCorrupt occurs when a record stored in the segment beginning at
+// [BaseOffset] fails verification. [Pos] is the record's position in the store.
+type ErrCorrupt struct {
+	BaseOffset, Pos uint64
+	Err             error
+}
+
+func (e ErrCorrupt) Error() string {
+	return fmt.Sprintf("segment %d: %v", e.BaseOffset, e.Err)
+}
+
+func (e ErrCorrupt) Unwrap() error {
+	return e.Err
+}
+
+// ErrFull is returned when an append would exceed the capacity of the index.
+var ErrFull = errors.New("segment is full")
+
+// SyncPolicy determines when a segment's store and index are forced to stable
+// storage. Regardless of the policy, a segment is always synced when it is
+// sealed or closed.
+type SyncPolicy uint8
+
+const (
+	SyncOnRoll   SyncPolicy = iota // SyncOnRoll only syncs when the segment is sealed.
+	SyncAlways                     // SyncAlways syncs after every append.
+	SyncEveryN                     // SyncEveryN syncs after every [Config.SyncRecords] appends.
+	SyncPeriodic                   // SyncPeriodic leaves syncing to a background flusher.
+)
+
 // Config governs the parameters of the [Segment], [Store], and [Index]
 // TODO: Determine whether this is the right place for this.
 type Config struct {
 	InitialOffset                uint64 // InitialOffset is the initial offset of the segment
 	MaxStoreBytes, MaxIndexBytes uint64
+
+	Codec         Codec         // Codec compresses the records of each batch written to the store.
+	IndexInterval uint64        // IndexInterval is the minimum number of store bytes between index entries, or 0 to index every record.
+	TimeInterval  uint64        // TimeInterval is the minimum number of store bytes between time index entries.
+	Sync          SyncPolicy    // Sync is the durability policy for appended records.
+	SyncRecords   uint64        // SyncRecords is the number of appends between syncs under [SyncEveryN].
+	SyncInterval  time.Duration // SyncInterval is the period of the flusher under [SyncPeriodic].
+	MaxSegmentAge time.Duration // MaxSegmentAge is the age beyond which a segment holding records is full, or 0 for no limit.
+
+	// Keys encrypts the records of new segments under its current key, and
+	// decrypts those of existing segments. If nil, new segments are not
+	// encrypted.
+	Keys keyring.Keyring
+}
+
+// Recovery describes what was repaired when reopening a segment whose files
+// were left inconsistent, e.g. by a crash in the middle of an append.
+type Recovery struct {
+	Records   uint64 // Records is the number of index entries discarded.
+	Bytes     uint64 // Bytes is the number of store bytes discarded.
+	Reindexed uint64 // Reindexed is the number of index entries rebuilt from the store.
+}
+
+// Add accumulates the totals of [r] and [other].
+func (r Recovery) Add(other Recovery) Recovery {
+	return Recovery{
+		r.Records + other.Records,
+		r.Bytes + other.Bytes,
+		r.Reindexed + other.Reindexed,
+	}
 }
 
 // Segment encapsulates operations on a [Store] and [Index], ensuring the
 // entries in both correspond.
+//
+// A segment has a single writer, but [Segment.Read] may be called concurrently
+// with it for any offset below [Segment.Watermark].
 type Segment struct {
-	store *store.Store
-	index *index.Index
+	store     *store.Store
+	index     *index.Index
+	timeIndex *index.Index // timeIndex maps timestamps to offsets (see timeindex.go)
 	Config
-	BaseOffset, NextOffset uint64 // TODO: Find a good way to describe these
+	BaseOffset, NextOffset uint64   // TODO: Find a good way to describe these
+	Recovery               Recovery // Recovery reports any data discarded by New.
+
+	unsynced     uint64        // The number of appends since the last sync.
+	maxTimestamp atomic.Int64  // The greatest timestamp of any record in milliseconds.
+	timeIndexed  uint64        // The size of the store when the time index was last written.
+	watermark    atomic.Uint64 // The offset following the last record visible to readers.
+
+	// pins counts the users of the segment which pinned it (see [Segment.Pin]),
+	// and closing records a call to [Segment.Close] deferred until they are
+	// done. Both are guarded by [pmu].
+	pmu     sync.Mutex
+	pins    int
+	closing bool
 }
 
 // func nearestMultiple(j, k uint64) uint64 {
 // 	return (j / k) * k
 // }
 
+// Flag modifies how [Open] treats the files of an existing segment.
+type Flag uint8
+
+const (
+	// Legacy also accepts a segment written before files had headers. Such a
+	// segment should be rewritten with [Segment.Clean], which gives its files
+	// headers.
+	Legacy Flag = 1 << iota
+
+	// Sealed marks a segment which was synced in full when it was sealed, so
+	// its store cannot end in a torn write. Damage to the store is reported as
+	// [ErrCorrupt] rather than truncated away along with every record after it.
+	Sealed
+)
+
 // New constructs a segment, initializing the encapsulated store and index and
-// creating their respective backing files.
+// creating their respective backing files. Each file begins with a header (see
+// [header.Header]), and a store whose header is missing or does not belong to
+// this segment is rejected. A torn write at the end of the store is truncated,
+// so New is suitable for the active segment.
 func New(dir string, baseOffset uint64, c Config) (*Segment, error) {
+	return Open(dir, baseOffset, c, 0)
+}
+
+// NewLegacy behaves like [New] with the [Legacy] flag.
+func NewLegacy(dir string, baseOffset uint64, c Config) (*Segment, error) {
+	return Open(dir, baseOffset, c, Legacy)
+}
+
+// Open behaves like [New], modified by [mode].
+func Open(dir string, baseOffset uint64, c Config, mode Flag) (*Segment, error) {
+	return open(dir, baseOffset, time.Now(), c, mode)
+}
+
+// open opens the segment at [baseOffset] under [dir]. Files created by open
+// record [created] as their creation time.
+func open(dir string, baseOffset uint64, created time.Time, c Config, mode Flag) (*Segment, error) {
 	var err error
 
 	s := Segment{
@@ -62,130 +179,720 @@ func New(dir string, baseOffset uint64, c Config) (*Segment, error) {
 		return nil, err
 	}
 
-	if s.store, err = store.New(storefile); err != nil {
+	path = filepath.Join(dir, fmt.Sprintf("%d%s", baseOffset, ".timeindex"))
+	timefile, err := os.OpenFile(path, flags, fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	h := header.Header{
+		Magic:      header.Store,
+		Version:    header.Version,
+		Codec:      uint8(c.Codec),
+		BaseOffset: baseOffset,
+		Created:    created,
+	}
+
+	// A new store is encrypted under the current key. An existing store keeps
+	// the key recorded in its header.
+	if c.Keys != nil {
+		if h.KeyID, _, err = c.Keys.Current(); err != nil {
+			return nil, err
+		}
+	}
+
+	newStore := store.New
+	if mode&Legacy != 0 {
+		newStore = store.NewLegacy
+	}
+
+	if s.store, err = newStore(storefile, h); err != nil {
+		return nil, err
+	}
+
+	if id := s.KeyID(); id != 0 {
+		if _, err := lookupKey(c.Keys, id); err != nil {
+			return nil, fmt.Errorf("segment %d: %w", baseOffset, err)
+		}
+	}
+
+	// The indexes take the store's creation time, which outlives them. Only
+	// the store is encrypted.
+	h.Created, h.KeyID = s.store.Header().Created, 0
+
+	h.Magic = header.Index
+	if s.index, err = openIndex(indexfile, c.MaxIndexBytes, h); err != nil {
 		return nil, err
 	}
 
-	if s.index, err = index.New(indexfile, c.MaxIndexBytes); err != nil {
+	h.Magic = header.TimeIndex
+	if s.timeIndex, err = openIndex(timefile, c.MaxIndexBytes, h); err != nil {
+		return nil, err
+	}
+
+	if s.Recovery, err = s.recover(mode&Sealed != 0); err != nil {
+		// The files are closed so that a damaged segment may be opened again,
+		// e.g. without [Sealed] to truncate the damage.
+		s.Close()
+
 		return nil, err
 	}
 
-	// If the index is empty, the next offset is simply the base. Otherwise, the
-	// nextOffset is computed by advancing exactly one byte past the last record
-	// in the index:
-	//
-	//  [ ... | ... ]
-	//  ^      ^
-	//  base   base+off+1
-	if off, _, err := s.index.Read(-1); err != nil {
-		s.NextOffset = baseOffset
-	} else {
-		s.NextOffset = baseOffset + uint64(off) + 1
+	s.watermark.Store(s.NextOffset)
+
+	// The time index is only an optimization, so it is simply brought in line
+	// with the records which survived recovery.
+	if err := s.recoverTimeIndex(); err != nil {
+		return nil, err
 	}
 
 	return &s, nil
 }
 
-// Append adds [record] to its store and index, returning its offset.
-func (s *Segment) Append(record *record.Record) (uint64, error) {
-	cur := s.NextOffset
-	record.Offset = cur
+// openIndex opens the index backed by [f]. Unlike the store, an index can be
+// rebuilt, so one whose header is unreadable (e.g. because it predates headers)
+// is discarded and left to [Segment.recover] to rebuild.
+func openIndex(f *os.File, maxBytes uint64, h header.Header) (*index.Index, error) {
+	i, err := index.New(f, maxBytes, h)
+	if errors.Is(err, header.ErrMagic) || errors.Is(err, header.ErrCorrupt) {
+		if err := f.Truncate(0); err != nil {
+			return nil, err
+		}
+
+		return index.New(f, maxBytes, h)
+	}
+
+	return i, err
+}
+
+// recover restores consistency between the store and index after an unclean
+// shutdown. Index entries are mmap'd and may outlive the buffered store bytes
+// they refer to, while the store may contain a partially written record. The
+// last index entry pointing to an intact store record is found by walking the
+// index backwards.
+//
+// Any intact batches in the store following that entry are then indexed. If
+// the index was missing or fails validation, this rebuilds it entirely from
+// the store. Finally, the store is truncated after the last intact batch, and
+// [NextOffset] follows the last record in it.
+//
+// If the segment is [sealed], a damaged batch found while walking the store is
+// instead reported as [ErrCorrupt], since it cannot be a torn write.
+func (s *Segment) recover(sealed bool) (Recovery, error) {
+	entries, size := s.index.Entries(), s.store.Size()
+
+	// The number of entries and the end of the last batch to keep, along with
+	// the offset at which the next batch may begin.
+	keep, end, from := uint64(0), uint64(0), s.BaseOffset
+	if s.indexValid() {
+		for k := entries; k > 0; k-- {
+			rel, pos, err := s.index.Read(int64(k - 1))
+			if err != nil {
+				return Recovery{}, err
+			}
+
+			off := s.BaseOffset + uint64(rel)
+
+			data, next, err := s.store.Scan(pos)
+			if err != nil {
+				if !isTorn(err) {
+					return Recovery{}, err
+				}
+
+				continue
+			}
+
+			h, _, err := parseBatch(data, s.BaseOffset)
+			if err != nil || off < h.base || off >= h.next() {
+				continue
+			}
+
+			// A sparse index only holds an entry for the first record of a batch.
+			if off == h.next()-1 || s.Config.IndexInterval > 0 && off == h.base {
+				keep, end, from = k, next, h.next()
+
+				break
+			}
+
+			// Entries are written after their batch, so a crash may leave a batch
+			// only partially indexed. Such a batch is indexed again below.
+			for keep, end, from = k-1, pos, h.base; keep > 0; keep-- {
+				_, prev, err := s.index.Read(int64(keep - 1))
+				if err != nil {
+					return Recovery{}, err
+				}
+
+				if prev != pos {
+					break
+				}
+			}
+
+			break
+		}
+	}
+
+	if err := s.index.Truncate(keep); err != nil {
+		return Recovery{}, err
+	}
+
+	r := Recovery{Records: entries - keep}
+
+	// Walk the store's length-prefixed batches from the end of the last indexed
+	// batch. Compaction may leave gaps between batches, but offsets in a segment
+	// always increase.
+	for {
+		data, next, err := s.store.Scan(end)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			if !isTorn(err) {
+				return Recovery{}, err
+			}
+
+			if sealed {
+				return Recovery{}, ErrCorrupt{s.BaseOffset, end, err}
+			}
 
-	// Encode the record into binary and persist to the store.
-	c, err := schema.GetCodec(schema.RECORD)
+			break
+		}
+
+		h, _, err := parseBatch(data, s.BaseOffset)
+		if err == nil && h.base < from {
+			err = fmt.Errorf("batch at offset %d precedes offset %d", h.base, from)
+		}
+
+		if err != nil {
+			if sealed {
+				return Recovery{}, ErrCorrupt{s.BaseOffset, end, err}
+			}
+
+			break
+		}
+
+		n, err := s.indexBatch(h, end)
+		if err != nil {
+			return Recovery{}, fmt.Errorf("rebuilding index of segment %d: %w", s.BaseOffset, err)
+		}
+
+		r.Reindexed += n
+		from, end = h.next(), next
+	}
+
+	if err := s.store.Truncate(end); err != nil {
+		return Recovery{}, err
+	}
+
+	r.Bytes = size - end
+	s.NextOffset = from
+
+	return r, nil
+}
+
+// indexValid reports whether the index could describe this segment's store.
+// Entries are already known to be in increasing order (see [index.New]), so an
+// index is plausible as long as it begins at the start of the store. Offsets
+// need not be dense, since compaction removes records from sealed segments and
+// a sparse index skips them.
+func (s *Segment) indexValid() bool {
+	if s.index.Entries() == 0 {
+		return true
+	}
+
+	_, pos, err := s.index.Read(0)
+
+	return err == nil && pos == 0
+}
+
+// isTorn reports whether [err] indicates a record is missing or incomplete as
+// opposed to a genuine I/O failure.
+func isTorn(err error) bool {
+	var corrupt store.ErrCorrupt
+
+	return err == io.EOF || errors.As(err, &corrupt)
+}
+
+// Append adds [rec] to its store and index, returning its offset.
+func (s *Segment) Append(rec *record.Record) (uint64, error) {
+	return s.AppendBatch([]*record.Record{rec})
+}
+
+// AppendBatch adds each of [records] to the store and index, assigning them
+// contiguous offsets beginning with the returned offset. The records are
+// written to the store together as a single batch compressed with the
+// configured [Codec], and each receives an index entry pointing to the batch
+// (see [Segment.indexBatch]). [ErrFull] is returned without modifying the
+// segment if the index cannot hold the entire batch.
+func (s *Segment) AppendBatch(records []*record.Record) (uint64, error) {
+	if !s.Fits(len(records)) {
+		return 0, ErrFull
+	}
+
+	base := s.NextOffset
+	if len(records) == 0 {
+		return base, nil
+	}
+
+	// Records without a timestamp from the producer are assigned the time of
+	// the append. Timestamps are stored with millisecond precision.
+	now := time.UnixMilli(time.Now().UnixMilli())
+	for _, rec := range records {
+		if rec.Timestamp.IsZero() {
+			rec.Timestamp = now
+		}
+	}
+
+	// Encode the records into binary and persist to the store.
+	c, err := recordCodec(schemaVersion)
 	if err != nil {
 		return 0, err
 	}
 
-	// Avro requires this type.
-	r := map[string]interface{}{
-		"value":  record.Value,
-		"offset": int32(record.Offset),
+	data := make([][]byte, len(records))
+	for i, rec := range records {
+		if data[i], err = encodeRecord(c, rec, base+uint64(i)); err != nil {
+			return 0, err
+		}
 	}
 
-	data, err := c.BinaryFromNative(nil, r)
+	h := batch{
+		codec:   s.Config.Codec,
+		version: schemaVersion,
+		base:    base,
+		count:   uint32(len(records)),
+		keyID:   s.KeyID(),
+	}
+
+	var key []byte
+	if h.keyID != 0 {
+		if key, err = lookupKey(s.Config.Keys, h.keyID); err != nil {
+			return 0, err
+		}
+	}
+
+	b, err := encodeBatch(h, data, key)
 	if err != nil {
 		return 0, err
 	}
 
-	_, pos, err := s.store.Append(data)
+	size, entries := s.store.Size(), s.index.Entries()
+
+	_, pos, err := s.store.Append(b)
+
+	// Readers never flush the store themselves, so the batch must be written
+	// out before its records are made visible below. The store's buffer then
+	// only serves to write the batch with its metadata in a single write.
+	if err == nil {
+		err = s.store.Flush()
+	}
+
+	if err == nil {
+		_, err = s.indexBatch(h, pos)
+	}
+
+	// A batch left in the store would hold the same offsets as the next one.
 	if err != nil {
+		if rerr := s.rollback(size, entries); rerr != nil {
+			return 0, fmt.Errorf("%w (undoing the append: %v)", err, rerr)
+		}
+
 		return 0, err
 	}
 
 	// TODO: I need a picture describing the relationship of these offsets.
 	// Add a nice diagram to the README later.
-	off := uint32(s.NextOffset - uint64(s.BaseOffset))
-	if err := s.index.Write(off, pos); err != nil {
+	for _, rec := range records {
+		rec.Offset = s.NextOffset
+		s.NextOffset++
+	}
+
+	s.watermark.Store(s.NextOffset)
+
+	if err := s.indexTime(records); err != nil {
 		return 0, err
 	}
 
-	s.NextOffset++
+	s.unsynced += uint64(len(records))
 
-	return cur, nil
+	if err := s.maybeSync(); err != nil {
+		return 0, err
+	}
+
+	return base, nil
+}
+
+// Watermark returns the offset following the last record readers may read. It
+// trails [NextOffset] while a batch is being appended, and is safe to call
+// concurrently with the writer.
+func (s *Segment) Watermark() uint64 {
+	return s.watermark.Load()
+}
+
+// rollback undoes an append which failed part way, discarding anything
+// written to the store after [size] and every index entry following the first
+// [entries].
+func (s *Segment) rollback(size, entries uint64) error {
+	if err := s.store.Truncate(size); err != nil {
+		return err
+	}
+
+	return s.index.Truncate(entries)
 }
 
-// Read retrieves the record in its store located at offset [off].
+// indexBatch adds index entries for the batch [h] located at [pos] in the
+// store, returning the number of entries written. Every record in the batch
+// receives an entry, unless [Config.IndexInterval] is set. A sparse index
+// instead holds an entry for the first record of a batch only once at least
+// that many store bytes have been written since the previous entry.
+func (s *Segment) indexBatch(h batch, pos uint64) (uint64, error) {
+	rel := h.base - s.BaseOffset
+	if s.Config.IndexInterval == 0 {
+		for i := uint64(0); i < uint64(h.count); i++ {
+			if err := s.index.Write(rel+i, pos); err != nil {
+				return 0, err
+			}
+		}
+
+		return uint64(h.count), nil
+	}
+
+	if _, last, err := s.index.Read(-1); err == nil && pos-last < s.Config.IndexInterval {
+		return 0, nil
+	}
+
+	return 1, s.index.Write(rel, pos)
+}
+
+// Fits reports whether a batch of [n] records may be appended to the segment.
+// The index must have room for the batch's entries, of which a sparse index
+// needs at most one, and the offset of each record relative to [BaseOffset]
+// must fit in an entry.
+func (s *Segment) Fits(n int) bool {
+	if n == 0 {
+		return true
+	}
+
+	if s.NextOffset-s.BaseOffset+uint64(n-1) > index.MaxOffset {
+		return false
+	}
+
+	if s.Config.IndexInterval > 0 {
+		n = 1
+	}
+
+	return uint64(n) <= s.index.Available()
+}
+
+// maybeSync syncs the segment if required by its [SyncPolicy].
+func (s *Segment) maybeSync() error {
+	switch s.Config.Sync {
+	case SyncAlways:
+		return s.Sync()
+	case SyncEveryN:
+		if s.unsynced >= s.Config.SyncRecords {
+			return s.Sync()
+		}
+	}
+
+	return nil
+}
+
+// Sync commits the segment's store and then its index to stable storage. Once
+// Sync returns, every record appended so far survives a power failure.
+func (s *Segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.timeIndex.Sync(); err != nil {
+		return err
+	}
+
+	s.unsynced = 0
+
+	return nil
+}
+
+// Read retrieves the record in its store located at offset [off]. Beginning at
+// the nearest index entry preceding [off], batches are read until the one
+// containing [off] is found, which is then decompressed and scanned for it.
+// If there is no record at [off], e.g. because it was removed by compaction,
+// [io.EOF] is returned.
 func (s *Segment) Read(off uint64) (*record.Record, error) {
-	c, err := schema.GetCodec(schema.RECORD)
+	// Essentially perform the inverse operations of [Append]
+	pos, err := s.seek(off)
 	if err != nil {
 		return nil, err
 	}
 
-	// Essentially perform the inverse operations of [Append]
-	_, pos, err := s.index.Read(int64(off - s.BaseOffset))
+	for {
+		h, raw, next, err := s.readBatch(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		// Batches are in order of their offsets, so [off] was skipped.
+		if off < h.base {
+			return nil, io.EOF
+		}
+
+		if off >= h.next() {
+			pos = next
+
+			continue
+		}
+
+		c, err := recordCodec(h.version)
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip over the records preceding [off] in the batch.
+		var rec *record.Record
+		for i := h.base; i <= off; i++ {
+			if rec, raw, err = decodeRecord(c, raw); err != nil {
+				return nil, err
+			}
+		}
+
+		return rec, nil
+	}
+}
+
+// seek returns the position of the batch from which to search for the record
+// at [off]: that of the last index entry at or before [off], or the start of
+// the store if there is none. The index of a segment with dense offsets holds
+// the entry for [off] at the same position. Otherwise, the segment has been
+// compacted or has a sparse index, and the index is searched.
+func (s *Segment) seek(off uint64) (uint64, error) {
+	rel := off - s.BaseOffset
+	if rel > index.MaxOffset {
+		return 0, io.EOF
+	}
+
+	if o, pos, err := s.index.Read(int64(rel)); err == nil && uint64(o) == rel {
+		return pos, nil
+	}
+
+	var err error
+
+	k := sort.Search(int(s.index.Entries()), func(k int) bool {
+		o, _, e := s.index.Read(int64(k))
+		if e != nil {
+			err = e
+		}
+
+		return uint64(o) > rel
+	})
+	if err != nil || k == 0 {
+		return 0, err
+	}
+
+	_, pos, err := s.index.Read(int64(k - 1))
+
+	return pos, err
+}
+
+// readBatch reads the batch at [pos] in the store, returning its header, its
+// decompressed records and the position of the following batch.
+func (s *Segment) readBatch(pos uint64) (batch, []byte, uint64, error) {
+	data, next, err := s.store.Scan(pos)
 	if err != nil {
-		return nil, err
+		var corrupt store.ErrCorrupt
+		if errors.As(err, &corrupt) {
+			return batch{}, nil, 0, ErrCorrupt{s.BaseOffset, corrupt.Pos, err}
+		}
+
+		return batch{}, nil, 0, err
 	}
 
-	data, err := s.store.Read(pos)
+	h, body, err := parseBatch(data, s.BaseOffset)
 	if err != nil {
-		return nil, err
+		return batch{}, nil, 0, ErrCorrupt{s.BaseOffset, pos, err}
+	}
+
+	raw, err := decodeBody(h, data, body, s.Config.Keys)
+	if errors.Is(err, keyring.ErrUnknownKey) {
+		return batch{}, nil, 0, err
 	}
 
-	rec, _, err := c.NativeFromBinary(data)
 	if err != nil {
-		return nil, err
+		return batch{}, nil, 0, ErrCorrupt{s.BaseOffset, pos, err}
+	}
+
+	return h, raw, next, nil
+}
+
+// Scan calls [fn] with each record in the segment in order, beginning with the
+// first record at or after offset [from], until [fn] returns false. Like
+// [Segment.Read], Scan only sees records below the watermark, and may be
+// called concurrently with the writer.
+func (s *Segment) Scan(from uint64, fn func(*record.Record) bool) error {
+	watermark := s.Watermark()
+	if from < s.BaseOffset || from >= watermark {
+		return nil
 	}
 
-	// I don't actually know if this assertion will ever fail.
-	if m, ok := rec.(map[string]interface{}); ok {
-		value, ok := m["value"]
-		if !ok {
-			return nil, fmt.Errorf("unable to retrieve 'value' from record")
+	pos, err := s.seek(from)
+	if err != nil {
+		return err
+	}
+
+	for pos < s.store.Committed() {
+		h, raw, next, err := s.readBatch(pos)
+		if err != nil {
+			return err
 		}
 
-		offset, ok := m["offset"]
-		if !ok {
-			return nil, fmt.Errorf("unable to retrieve 'offset' from record")
+		c, err := recordCodec(h.version)
+		if err != nil {
+			return err
 		}
 
-		// Let it panic. See if I care...
-		return &record.Record{
-			Offset: uint64(offset.(int64)),
-			Value:  value.([]byte),
-		}, nil
-	} else {
-		return nil, fmt.Errorf("invalid type. %v is not a map", rec)
+		for i := uint32(0); i < h.count; i++ {
+			var rec *record.Record
+			if rec, raw, err = decodeRecord(c, raw); err != nil {
+				return err
+			}
+
+			if rec.Offset < from {
+				continue
+			}
+
+			if rec.Offset >= watermark || !fn(rec) {
+				return nil
+			}
+		}
+
+		pos = next
+	}
+
+	return nil
+}
+
+// Reader returns a reader of the contents of the segment's store, i.e. its
+// length-prefixed batches without the header. Only the bytes committed when
+// Reader is called are read. The records may be recovered from the contents
+// with [store.Decoder] and [DecodeBatch].
+func (s *Segment) Reader() *io.SectionReader {
+	return io.NewSectionReader(s.store, 0, int64(s.store.Committed()))
+}
+
+// DecodeBatch returns the records of the batch [b], as read from a segment's
+// store. Only batches with 64-bit headers can be decoded out of the context of
+// their segment, so legacy batches are rejected. An encrypted batch is
+// decrypted with the key from [keys].
+func DecodeBatch(b []byte, keys keyring.Keyring) ([]*record.Record, error) {
+	if len(b) == 0 || b[0]&wideMask == 0 {
+		return nil, errBatch
 	}
+
+	h, body, err := parseBatch(b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeBody(h, b, body, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := recordCodec(h.version)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*record.Record, h.count)
+	for i := range records {
+		if records[i], raw, err = decodeRecord(c, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
 }
 
 // IsFull returns whether the segment is currently full, that is, either its
-// store or index is at capacity. This is used by clients to determine whether
-// a new segment should be created.
+// store or index is at capacity, the next offset cannot be stored in the
+// index, or the segment has expired (see [Segment.Expired]). This is used by
+// clients to determine whether a new segment should be created.
 func (s *Segment) IsFull() bool {
 	return s.index.Size() >= s.Config.MaxIndexBytes ||
-		s.store.Size() >= s.Config.MaxStoreBytes
+		s.store.Size() >= s.Config.MaxStoreBytes ||
+		!s.Fits(1) ||
+		s.Expired(time.Now())
+}
+
+// Expired reports whether the segment is older than [Config.MaxSegmentAge] as
+// of [now]. A segment without records never expires, since rolling it would
+// produce another segment at the same offset.
+func (s *Segment) Expired(now time.Time) bool {
+	return s.Config.MaxSegmentAge > 0 &&
+		s.NextOffset > s.BaseOffset &&
+		now.Sub(s.Created()) >= s.Config.MaxSegmentAge
+}
+
+// Created returns when the segment was created, as recorded in the header of
+// its store.
+func (s *Segment) Created() time.Time {
+	return s.store.Header().Created
+}
+
+// KeyID returns the ID of the key encrypting the segment's records, as
+// recorded in the header of its store, or 0 if they are not encrypted.
+func (s *Segment) KeyID() uint32 {
+	return s.store.Header().KeyID
+}
+
+// Files returns the names of the files backing the segment: its store, index
+// and time index, in that order.
+func (s *Segment) Files() []string {
+	return []string{s.store.Name(), s.index.Name(), s.timeIndex.Name()}
+}
+
+// Size returns the number of bytes held by the segment's store.
+func (s *Segment) Size() uint64 {
+	return s.store.Size()
 }
 
 // Close invokes the respective Close operations on the store and index. This
 // flushes any data in-memory or in a buffer to disk and truncates the backing
 // files to their corresponding sizes.
+//
+// If the segment is pinned, closing it is deferred until it is unpinned.
 func (s *Segment) Close() error {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+
+	if s.pins > 0 {
+		s.closing = true
+
+		return nil
+	}
+
+	return s.close()
+}
+
+// close implements [Segment.Close]. The caller must hold [pmu].
+func (s *Segment) close() error {
+	// The index is synced as part of closing it, but the store is not.
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+
 	if err := s.index.Close(); err != nil {
 		return err
 	}
 
+	if err := s.timeIndex.Close(); err != nil {
+		return err
+	}
+
 	if err := s.store.Close(); err != nil {
 		return err
 	}
@@ -193,8 +900,36 @@ func (s *Segment) Close() error {
 	return nil
 }
 
-// Remove closes the segment and deletes the files backing the index and store
-// from disk.
+// Pin keeps the files of the segment open until a matching call to
+// [Segment.Unpin], even if it is closed or removed in the meantime. A segment
+// pinned while it is known to be open may then be read without holding the lock
+// of its log, e.g. while streaming it or rewriting it.
+func (s *Segment) Pin() {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+
+	s.pins++
+}
+
+// Unpin releases a segment pinned by [Segment.Pin], closing it if it was closed
+// while pinned. Any error from closing it is returned.
+func (s *Segment) Unpin() error {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+
+	s.pins--
+	if s.pins > 0 || !s.closing {
+		return nil
+	}
+
+	s.closing = false
+
+	return s.close()
+}
+
+// Remove closes the segment and deletes the files backing the indexes and
+// store from disk. The files of a pinned segment are deleted at once, but
+// remain readable until it is unpinned.
 func (s *Segment) Remove() error {
 	if err := s.Close(); err != nil {
 		return err
@@ -204,6 +939,10 @@ func (s *Segment) Remove() error {
 		return err
 	}
 
+	if err := os.Remove(s.timeIndex.Name()); err != nil {
+		return err
+	}
+
 	if err := os.Remove(s.store.Name()); err != nil {
 		return err
 	}
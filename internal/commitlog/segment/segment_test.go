@@ -1,13 +1,22 @@
 package segment
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/beautifultovarisch/dlog/internal/schema"
 
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/index"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
 	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/store"
 )
 
 const (
@@ -85,12 +94,26 @@ func TestSegment(t *testing.T) {
 			t.Errorf("expected record offset=%d. Got %d", record.Offset, off)
 		}
 
-		// Read the index to retrieve the position in the store. Deserialize and
-		// ensure the original record's data matches.
-		pos, _, _ := s.index.Read(-1)
-		data, _ := s.store.Read(uint64(pos))
+		// Read the index to retrieve the position in the store. Unpack the batch,
+		// deserialize and ensure the original record's data matches.
+		_, pos, _ := s.index.Read(-1)
+		data, _ := s.store.Read(pos)
 
-		rec, _, err := c.NativeFromBinary(data)
+		h, body, err := parseBatch(data, 0)
+		if err != nil {
+			t.Fatalf("error parsing batch: %v", err)
+		}
+
+		if h.count != 1 {
+			t.Errorf("expected batch of %d record. Got %d", 1, h.count)
+		}
+
+		raw, err := decompress(h.codec, body)
+		if err != nil {
+			t.Fatalf("error decompressing batch: %v", err)
+		}
+
+		rec, _, err := c.NativeFromBinary(raw)
 		if err != nil {
 			t.Errorf("error decoding store record: %v", err)
 		}
@@ -104,7 +127,7 @@ func TestSegment(t *testing.T) {
 			}
 
 			// A hack since directly converting to string does not work.
-			if actual := fmt.Sprintf("%s", value); string(msg) != actual {
+			if actual := fmt.Sprintf("%s", fromNullable(value)); string(msg) != actual {
 				t.Errorf("expected: %s. Got: %s", msg, actual)
 			}
 		}
@@ -131,6 +154,162 @@ func TestSegment(t *testing.T) {
 		}
 	})
 
+	run("Corrupt", func(s *Segment, t *testing.T) {
+		off, err := s.Append(&record.Record{Value: []byte("precious")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Flush the store so the record is on disk before mangling it.
+		if _, err := s.Read(off); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.OpenFile(s.store.Name(), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		// Overwrite the final byte of the encoded record.
+		if _, err := f.WriteAt([]byte{0xff}, int64(header.Width+s.store.Size()-1)); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = s.Read(off)
+
+		var corrupt ErrCorrupt
+		if !errors.As(err, &corrupt) {
+			t.Fatalf("expected ErrCorrupt. Got: %v", err)
+		}
+
+		if corrupt.BaseOffset != s.BaseOffset {
+			t.Errorf("expected base offset %d. Got %d", s.BaseOffset, corrupt.BaseOffset)
+		}
+	})
+
+	run("AppendBatch", func(s *Segment, t *testing.T) {
+		batch := []*record.Record{
+			{Value: []byte("a")},
+			{Value: []byte("b")},
+		}
+
+		base, err := s.AppendBatch(batch)
+		if err != nil {
+			t.Fatalf("error appending batch: %v", err)
+		}
+
+		for i, rec := range batch {
+			if expected := base + uint64(i); rec.Offset != expected {
+				t.Errorf("expected record offset=%d. Got %d", expected, rec.Offset)
+			}
+
+			actual, err := s.Read(rec.Offset)
+			if err != nil {
+				t.Fatalf("error reading record: %v", err)
+			}
+
+			if string(actual.Value) != string(rec.Value) {
+				t.Errorf("expected: %s. Got: %s", rec.Value, actual.Value)
+			}
+		}
+
+		// Only a single entry remains in the index, so a batch of two must be
+		// rejected without writing anything.
+		size := s.store.Size()
+		if _, err := s.AppendBatch(batch); err != ErrFull {
+			t.Errorf("expected ErrFull. Got: %v", err)
+		}
+
+		if s.store.Size() != size || s.NextOffset != base+2 {
+			t.Error("rejected batch modified the segment")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes, MaxSegmentAge: time.Hour}
+
+		seg, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		later := seg.Created().Add(time.Hour)
+
+		// An empty segment never expires.
+		if seg.Expired(later) {
+			t.Error("expected empty segment not to expire")
+		}
+
+		if _, err := seg.Append(&record.Record{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if seg.Expired(later.Add(-time.Millisecond)) || !seg.Expired(later) {
+			t.Errorf("expected segment created at %v to expire at %v", seg.Created(), later)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The creation time survives reopening the segment.
+		seg, err = New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if !seg.Expired(later) {
+			t.Errorf("expected reopened segment to expire at %v. Created %v", later, seg.Created())
+		}
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		seg, err := New(t.TempDir(), 0, Config{
+			MaxStoreBytes: maxBytes,
+			MaxIndexBytes: maxBytes,
+			Sync:          SyncEveryN,
+			SyncRecords:   3,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		for i := 1; i <= 4; i++ {
+			if _, err := seg.Append(&record.Record{Value: []byte("synced")}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The third append triggers a sync, resetting the count.
+			if expected := uint64(i % 3); seg.unsynced != expected {
+				t.Errorf("expected %d unsynced records. Got %d", expected, seg.unsynced)
+			}
+		}
+
+		// Nothing may be left in the store's buffer after a sync.
+		if err := seg.Sync(); err != nil {
+			t.Fatal(err)
+		}
+
+		stat, err := os.Stat(seg.store.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size := uint64(stat.Size()); size != header.Width+seg.store.Size() {
+			t.Errorf("expected %d bytes on disk. Got %d", header.Width+seg.store.Size(), size)
+		}
+	})
+
 	run("Full", func(s *Segment, t *testing.T) {
 		// Insert three records and ensure the segment is full.
 		for i := 0; i < 3; i++ {
@@ -148,4 +327,1046 @@ func TestSegment(t *testing.T) {
 			t.Errorf("Expected segment to be full. size (index=%d, store=%d)", s.index.Size(), s.store.Size())
 		}
 	})
+
+	t.Run("Recover", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := seg.Append(&record.Record{Value: []byte("durable")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		size := seg.store.Size()
+
+		// This record is indexed but its bytes are then cut from the store,
+		// simulating a crash partway through an append.
+		if _, err := seg.Append(&record.Record{Value: []byte("lost")}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Truncate(seg.store.Name(), int64(header.Width+size)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Additionally leave a torn record at the end of the store.
+		f, err := os.OpenFile(seg.store.Name(), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Write([]byte{0, 0, 0}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		// Reopen the segment without closing the original one.
+		recovered, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatalf("error recovering segment: %v", err)
+		}
+
+		t.Cleanup(func() {
+			recovered.Close()
+		})
+
+		if recovered.NextOffset != 3 {
+			t.Errorf("expected next offset of %d. Got %d", 3, recovered.NextOffset)
+		}
+
+		if expected := (Recovery{Records: 1, Bytes: 3}); recovered.Recovery != expected {
+			t.Errorf("expected recovery of %+v. Got %+v", expected, recovered.Recovery)
+		}
+
+		for off := uint64(0); off < 3; off++ {
+			if _, err := recovered.Read(off); err != nil {
+				t.Errorf("error reading recovered record %d: %v", off, err)
+			}
+		}
+
+		// The segment remains usable after recovery.
+		off, err := recovered.Append(&record.Record{Value: []byte("after")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if off != 3 {
+			t.Errorf("expected offset of %d. Got %d", 3, off)
+		}
+	})
+
+	t.Run("Sealed", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sizes []uint64
+		for i := 0; i < 3; i++ {
+			if _, err := seg.Append(&record.Record{Value: []byte("durable")}); err != nil {
+				t.Fatal(err)
+			}
+
+			sizes = append(sizes, seg.store.Size())
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flip the last byte of the second batch, and remove the index so that
+		// the whole store is walked.
+		f, err := os.OpenFile(seg.store.Name(), os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := make([]byte, 1)
+		pos := int64(header.Width + sizes[1] - 1)
+		if _, err := f.ReadAt(b, pos); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.WriteAt([]byte{^b[0]}, pos); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		if err := os.Remove(seg.index.Name()); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = Open(dir, 0, c, Sealed)
+
+		var corrupt ErrCorrupt
+		if !errors.As(err, &corrupt) {
+			t.Fatalf("expected ErrCorrupt. Got: %v", err)
+		}
+
+		if corrupt.Pos != sizes[0] {
+			t.Errorf("expected corruption at position %d. Got %d", sizes[0], corrupt.Pos)
+		}
+
+		// The records following the damage are kept.
+		info, err := os.Stat(seg.store.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if size := uint64(info.Size()); size != header.Width+sizes[2] {
+			t.Errorf("expected store of %d bytes. Got %d", header.Width+sizes[2], size)
+		}
+	})
+
+	t.Run("Rebuild", func(t *testing.T) {
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		// Each case damages the index of a closed segment holding three records.
+		tests := map[string]func(path string) error{
+			"Missing": os.Remove,
+			"Empty": func(path string) error {
+				return os.Truncate(path, 0)
+			},
+			"Corrupt": func(path string) error {
+				return os.WriteFile(path, []byte{0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 9}, fileMode)
+			},
+		}
+
+		for name, damage := range tests {
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+
+				seg, err := New(dir, 16, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for i := 0; i < 3; i++ {
+					if _, err := seg.Append(&record.Record{Value: []byte{byte(i)}}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				if err := seg.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := damage(seg.index.Name()); err != nil {
+					t.Fatal(err)
+				}
+
+				rebuilt, err := New(dir, 16, c)
+				if err != nil {
+					t.Fatalf("error rebuilding segment: %v", err)
+				}
+
+				t.Cleanup(func() {
+					rebuilt.Close()
+				})
+
+				if rebuilt.Recovery.Reindexed != 3 {
+					t.Errorf("expected %d entries rebuilt. Got %d", 3, rebuilt.Recovery.Reindexed)
+				}
+
+				if rebuilt.NextOffset != 19 {
+					t.Errorf("expected next offset of %d. Got %d", 19, rebuilt.NextOffset)
+				}
+
+				for i := 0; i < 3; i++ {
+					rec, err := rebuilt.Read(uint64(16 + i))
+					if err != nil {
+						t.Fatalf("error reading rebuilt record: %v", err)
+					}
+
+					if rec.Value[0] != byte(i) {
+						t.Errorf("expected value %d. Got %d", i, rec.Value[0])
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Pin", func(t *testing.T) {
+		seg, err := New(t.TempDir(), 0, Config{MaxIndexBytes: maxBytes})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := seg.Append(&record.Record{Value: []byte("pinned")}); err != nil {
+			t.Fatal(err)
+		}
+
+		seg.Pin()
+
+		// The files of a pinned segment outlive its removal.
+		if err := seg.Remove(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(seg.store.Name()); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected store to be deleted. Got %v", err)
+		}
+
+		rec, err := seg.Read(0)
+		if err != nil {
+			t.Fatalf("error reading pinned segment: %v", err)
+		}
+
+		if string(rec.Value) != "pinned" {
+			t.Errorf("expected value %q. Got %q", "pinned", rec.Value)
+		}
+
+		if err := seg.Unpin(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := seg.store.File.Stat(); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("expected segment to be closed once unpinned. Got %v", err)
+		}
+	})
+
+	t.Run("Clean", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if _, err := seg.Append(&record.Record{Value: []byte{byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Remove the records at 17 and 20, leaving a gap and a shorter segment.
+		seg, removed, err := seg.Clean(func(rec *record.Record) bool {
+			return rec.Offset != 17 && rec.Offset != 20
+		})
+		if err != nil {
+			t.Fatalf("error cleaning segment: %v", err)
+		}
+
+		if removed != 2 {
+			t.Errorf("expected %d records removed. Got %d", 2, removed)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		cleaned, err := New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			cleaned.Close()
+		})
+
+		if cleaned.Recovery != (Recovery{}) {
+			t.Errorf("expected no recovery. Got %+v", cleaned.Recovery)
+		}
+
+		if cleaned.NextOffset != 20 {
+			t.Errorf("expected next offset of %d. Got %d", 20, cleaned.NextOffset)
+		}
+
+		if _, err := cleaned.Read(17); err != io.EOF {
+			t.Errorf("expected %v reading removed record. Got %v", io.EOF, err)
+		}
+
+		var offsets []uint64
+		err = cleaned.Scan(17, func(rec *record.Record) bool {
+			if rec.Value[0] != byte(rec.Offset-16) {
+				t.Errorf("expected value %d. Got %d", rec.Offset-16, rec.Value[0])
+			}
+
+			offsets = append(offsets, rec.Offset)
+
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(offsets) != "[18 19]" {
+			t.Errorf("expected offsets [18 19]. Got %v", offsets)
+		}
+	})
+
+	t.Run("SchemaEvolution", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Write a batch with each version of the record schema preceding the
+		// current one.
+		old := []map[string]interface{}{
+			{"offset": int64(0), "value": []byte("v0")},
+			{"offset": int64(1), "value": []byte("v1"), "timestamp": int64(1000)},
+			{"offset": int64(2), "key": nullable([]byte("k")), "value": nullable([]byte("v2")), "timestamp": int64(2000)},
+		}
+
+		for i, native := range old {
+			codec, err := recordCodec(uint8(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := codec.BinaryFromNative(nil, native)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := encodeBatch(batch{base: uint64(i), count: 1, version: uint8(i)}, [][]byte{data}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, _, err := seg.store.Append(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The batch is indexed when the segment is reopened.
+		seg, err = New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		headers := map[string]string{"trace": "abc"}
+		if _, err := seg.Append(&record.Record{Value: []byte("new"), Headers: headers}); err != nil {
+			t.Fatal(err)
+		}
+
+		for i, want := range []struct {
+			key, value string
+			timestamp  int64
+		}{{"", "v0", 0}, {"", "v1", 1000}, {"k", "v2", 2000}} {
+			rec, err := seg.Read(uint64(i))
+			if err != nil {
+				t.Fatalf("error reading record with schema version %d: %v", i, err)
+			}
+
+			if string(rec.Key) != want.key || string(rec.Value) != want.value || rec.Headers != nil {
+				t.Errorf("expected record %s=%s without headers. Got %s=%s %v", want.key, want.value, rec.Key, rec.Value, rec.Headers)
+			}
+
+			if rec.Timestamp.UnixMilli() != want.timestamp {
+				t.Errorf("expected timestamp of %d. Got %d", want.timestamp, rec.Timestamp.UnixMilli())
+			}
+		}
+
+		rec, err := seg.Read(3)
+		if err != nil {
+			t.Fatalf("error reading record with current schema: %v", err)
+		}
+
+		if rec.Key != nil || string(rec.Value) != "new" || rec.Headers["trace"] != "abc" {
+			t.Errorf("expected record =new with headers %v. Got %s=%s %v", headers, rec.Key, rec.Value, rec.Headers)
+		}
+	})
+
+	t.Run("Sparse", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes, IndexInterval: 100}
+
+		seg, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each batch of two records occupies roughly 40 bytes of the store.
+		for i := 0; i < 20; i += 2 {
+			batch := []*record.Record{{Value: []byte{byte(i)}}, {Value: []byte{byte(i + 1)}}}
+			if _, err := seg.AppendBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n := seg.index.Entries(); n == 0 || n >= 10 {
+			t.Errorf("expected fewer index entries than batches. Got %d", n)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		seg, err = New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if seg.Recovery != (Recovery{}) {
+			t.Errorf("expected no recovery. Got %+v", seg.Recovery)
+		}
+
+		if seg.NextOffset != 20 {
+			t.Errorf("expected next offset of %d. Got %d", 20, seg.NextOffset)
+		}
+
+		for i := 0; i < 20; i++ {
+			rec, err := seg.Read(uint64(i))
+			if err != nil {
+				t.Fatalf("error reading record %d: %v", i, err)
+			}
+
+			if rec.Value[0] != byte(i) {
+				t.Errorf("expected value %d. Got %d", i, rec.Value[0])
+			}
+		}
+
+		if _, err := seg.Read(20); err != io.EOF {
+			t.Errorf("expected %v reading past the end. Got %v", io.EOF, err)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		codec, err := schema.GetCodec(schema.RECORD_V0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := codec.BinaryFromNative(nil, map[string]interface{}{
+			"offset": int64(16),
+			"value":  []byte("legacy"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A batch header with a 32-bit relative base offset of 0 and one record.
+		legacy := append([]byte{byte(CodecNone), 0, 0, 0, 0, 0, 0, 0, 1}, data...)
+		if _, _, err := seg.store.Append(legacy); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		seg, err = New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if seg.NextOffset != 17 {
+			t.Errorf("expected next offset of %d. Got %d", 17, seg.NextOffset)
+		}
+
+		// Rewriting the segment upgrades its batches.
+		seg, _, err = seg.Clean(func(*record.Record) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec, err := seg.Read(16)
+		if err != nil {
+			t.Fatalf("error reading legacy record: %v", err)
+		}
+
+		if string(rec.Value) != "legacy" {
+			t.Errorf("expected value legacy. Got %s", rec.Value)
+		}
+
+		b, err := seg.store.Read(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b[0]&wideMask == 0 {
+			t.Error("expected batch with 64-bit header after rewrite")
+		}
+	})
+
+	t.Run("Migrate", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		codec, err := schema.GetCodec(schema.RECORD_V0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := codec.BinaryFromNative(nil, map[string]interface{}{
+			"offset": int64(16),
+			"value":  []byte("baseline"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A baseline store whose second record was torn.
+		baseline := enc.AppendUint64(nil, uint64(len(data)))
+		baseline = append(baseline, data...)
+		baseline = append(enc.AppendUint64(baseline, uint64(len(data))), data[:2]...)
+
+		path := filepath.Join(dir, "16.store")
+		if err := os.WriteFile(path, baseline, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		// A sealed segment cannot end in a torn write, so it is left as it is.
+		var corrupt ErrCorrupt
+		if _, err := Migrate(dir, 16, c, Sealed); !errors.As(err, &corrupt) {
+			t.Fatalf("expected ErrCorrupt. Got: %v", err)
+		}
+
+		if b, err := os.ReadFile(path); err != nil || !bytes.Equal(b, baseline) {
+			t.Fatalf("expected store to be left as it was. Got %v", err)
+		}
+
+		seg, err := Migrate(dir, 16, c, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := uint64(baselineLenWidth + 2); seg.Recovery.Bytes != want {
+			t.Errorf("expected %d bytes discarded. Got %d", want, seg.Recovery.Bytes)
+		}
+
+		if rec, err := seg.Read(16); err != nil || string(rec.Value) != "baseline" {
+			t.Errorf("expected migrated record. Got %v (%v)", rec, err)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// A migrated segment is only opened.
+		seg, err = Migrate(dir, 16, c, Sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if seg.NextOffset != 17 {
+			t.Errorf("expected next offset of %d. Got %d", 17, seg.NextOffset)
+		}
+	})
+
+	t.Run("Headers", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := seg.Append(&record.Record{Value: []byte{byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Strip the headers, leaving files as they were written before headers
+		// were introduced.
+		for _, name := range []string{seg.store.Name(), seg.index.Name()} {
+			b, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(name, b[header.Width:], fileMode); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := New(dir, 16, c); !errors.Is(err, header.ErrMagic) {
+			t.Fatalf("expected ErrMagic opening headerless segment. Got: %v", err)
+		}
+
+		seg, err = NewLegacy(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seg.NextOffset != 19 {
+			t.Errorf("expected next offset of %d. Got %d", 19, seg.NextOffset)
+		}
+
+		created := seg.Created()
+
+		seg, _, err = seg.Clean(func(*record.Record) bool { return true })
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The rewritten segment has headers, and keeps its creation time.
+		seg, err = New(dir, 16, c)
+		if err != nil {
+			t.Fatalf("error reopening rewritten segment: %v", err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if !seg.Created().Equal(created.Truncate(time.Millisecond)) {
+			t.Errorf("expected creation time %v. Got %v", created, seg.Created())
+		}
+
+		for i := 0; i < 3; i++ {
+			rec, err := seg.Read(uint64(16 + i))
+			if err != nil {
+				t.Fatalf("error reading rewritten record: %v", err)
+			}
+
+			if rec.Value[0] != byte(i) {
+				t.Errorf("expected value %d. Got %d", i, rec.Value[0])
+			}
+		}
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(t.TempDir(), 1<<40, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		// Pretend the segment already holds as many records as the index allows
+		// but one.
+		seg.NextOffset = seg.BaseOffset + index.MaxOffset
+
+		if seg.Fits(2) {
+			t.Error("expected batch overflowing relative offsets not to fit")
+		}
+
+		off, err := seg.Append(&record.Record{Value: []byte("last")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := uint64(1<<40 + index.MaxOffset); off != expected {
+			t.Errorf("expected offset of %d. Got %d", expected, off)
+		}
+
+		if !seg.IsFull() {
+			t.Error("expected segment to be full")
+		}
+
+		if _, err := seg.Append(&record.Record{}); err != ErrFull {
+			t.Errorf("expected %v. Got %v", ErrFull, err)
+		}
+
+		rec, err := seg.Read(off)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Offset != off {
+			t.Errorf("expected offset of %d. Got %d", off, rec.Offset)
+		}
+	})
+
+	t.Run("Truncate", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Batches hold offsets [16, 18] and [19, 20].
+		for _, n := range []int{3, 2} {
+			batch := make([]*record.Record, n)
+			for i := range batch {
+				batch[i] = &record.Record{Value: []byte{byte(i)}}
+			}
+
+			if _, err := seg.AppendBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Cutting the first batch in two rewrites it.
+		if err := seg.Truncate(17); err != nil {
+			t.Fatalf("error truncating segment: %v", err)
+		}
+
+		if seg.NextOffset != 18 || seg.Watermark() != 18 {
+			t.Errorf("expected next offset of %d. Got %d", 18, seg.NextOffset)
+		}
+
+		if _, err := seg.Read(18); err != io.EOF {
+			t.Errorf("expected EOF reading truncated record. Got %v", err)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		seg, err = New(dir, 16, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if seg.NextOffset != 18 {
+			t.Errorf("expected next offset of %d after reopening. Got %d", 18, seg.NextOffset)
+		}
+
+		for off := uint64(16); off < 18; off++ {
+			rec, err := seg.Read(off)
+			if err != nil {
+				t.Fatalf("error reading record %d: %v", off, err)
+			}
+
+			if rec.Value[0] != byte(off-16) {
+				t.Errorf("expected value %d. Got %d", off-16, rec.Value[0])
+			}
+		}
+
+		if err := seg.Truncate(15); err != nil {
+			t.Fatal(err)
+		}
+
+		if seg.NextOffset != 16 || seg.Size() != 0 || seg.index.Entries() != 0 {
+			t.Errorf("expected empty segment. Got next offset %d and %d store bytes", seg.NextOffset, seg.Size())
+		}
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		payload := []byte(`{"service": "billing", "status": "ok", "latency_ms": 12}`)
+
+		sizes := make(map[Codec]uint64)
+		for _, codec := range []Codec{CodecNone, CodecGzip, CodecFlate} {
+			dir := t.TempDir()
+			c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes, Codec: codec}
+
+			seg, err := New(dir, 0, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			batch := make([]*record.Record, 20)
+			for i := range batch {
+				batch[i] = &record.Record{Value: payload}
+			}
+
+			if _, err := seg.AppendBatch(batch); err != nil {
+				t.Fatalf("error appending batch with codec %d: %v", codec, err)
+			}
+
+			sizes[codec] = seg.store.Size()
+
+			// Reopen with a missing index to ensure batches can be reindexed.
+			if err := seg.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.Remove(seg.index.Name()); err != nil {
+				t.Fatal(err)
+			}
+
+			if seg, err = New(dir, 0, c); err != nil {
+				t.Fatal(err)
+			}
+
+			if seg.NextOffset != 20 {
+				t.Errorf("expected next offset of %d. Got %d", 20, seg.NextOffset)
+			}
+
+			for off := uint64(0); off < 20; off++ {
+				rec, err := seg.Read(off)
+				if err != nil {
+					t.Fatalf("error reading offset %d with codec %d: %v", off, codec, err)
+				}
+
+				if rec.Offset != off || string(rec.Value) != string(payload) {
+					t.Errorf("unexpected record at offset %d: %+v", off, rec)
+				}
+			}
+
+			seg.Close()
+		}
+
+		for _, codec := range []Codec{CodecGzip, CodecFlate} {
+			if sizes[codec] >= sizes[CodecNone] {
+				t.Errorf("codec %d did not compress: %d >= %d bytes", codec, sizes[codec], sizes[CodecNone])
+			}
+		}
+	})
+
+	t.Run("Encryption", func(t *testing.T) {
+		dir := t.TempDir()
+
+		keys, err := keyring.OpenFile(filepath.Join(dir, "keys"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes, Codec: CodecGzip, Keys: keys}
+
+		seg, err := New(dir, 0, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := []byte("plaintext payload")
+		for i := 0; i < 3; i++ {
+			if _, err := seg.Append(&record.Record{Value: payload}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if id := seg.KeyID(); id != 1 {
+			t.Errorf("expected key ID %d. Got %d", 1, id)
+		}
+
+		stored, err := io.ReadAll(seg.Reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Contains(stored, payload) {
+			t.Error("expected store to be encrypted")
+		}
+
+		// Rotating the key leaves the segment readable, and cleaning it
+		// encrypts it under the new key.
+		if _, err := keys.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if seg, _, err = seg.Clean(func(*record.Record) bool { return true }); err != nil {
+			t.Fatal(err)
+		}
+
+		if id := seg.KeyID(); id != 2 {
+			t.Errorf("expected key ID %d after cleaning. Got %d", 2, id)
+		}
+
+		for off := uint64(0); off < 3; off++ {
+			rec, err := seg.Read(off)
+			if err != nil {
+				t.Fatalf("error reading offset %d: %v", off, err)
+			}
+
+			if !bytes.Equal(rec.Value, payload) {
+				t.Errorf("expected %q. Got %q", payload, rec.Value)
+			}
+		}
+
+		// Batches can be decrypted outside of their segment given the keys.
+		dec := store.NewDecoder(seg.Reader())
+
+		b, err := dec.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := DecodeBatch(b, nil); !errors.Is(err, keyring.ErrUnknownKey) {
+			t.Errorf("expected %v. Got %v", keyring.ErrUnknownKey, err)
+		}
+
+		if records, err := DecodeBatch(b, keys); err != nil || !bytes.Equal(records[0].Value, payload) {
+			t.Errorf("expected %q. Got %v (%v)", payload, records, err)
+		}
+
+		// Tampering with a batch fails authentication.
+		b[len(b)-1] ^= 1
+		if _, err := DecodeBatch(b, keys); err != errSealed {
+			t.Errorf("expected %v. Got %v", errSealed, err)
+		}
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The segment cannot be opened without its key.
+		c.Keys = nil
+		if _, err := New(dir, 0, c); !errors.Is(err, keyring.ErrUnknownKey) {
+			t.Errorf("expected %v. Got %v", keyring.ErrUnknownKey, err)
+		}
+	})
+
+	t.Run("OffsetForTime", func(t *testing.T) {
+		dir := t.TempDir()
+		c := Config{MaxStoreBytes: maxBytes, MaxIndexBytes: maxBytes}
+
+		seg, err := New(dir, 10, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		epoch := time.UnixMilli(1_000_000)
+		at := func(sec int) time.Time {
+			return epoch.Add(time.Duration(sec) * time.Second)
+		}
+
+		// Producer supplied timestamps, deliberately out of order at offset 13.
+		for _, sec := range []int{0, 10, 20, 5, 30} {
+			if _, err := seg.Append(&record.Record{Timestamp: at(sec)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			sec   int
+			off   uint64
+			found bool
+		}{
+			{-10, 10, true},
+			{0, 10, true},
+			{1, 11, true},
+			{15, 12, true},
+			{20, 12, true},
+			{25, 14, true},
+			{31, 0, false},
+		}
+
+		check := func(seg *Segment) {
+			for _, test := range tests {
+				off, found, err := seg.OffsetForTime(at(test.sec))
+				if err != nil {
+					t.Fatalf("error looking up time: %v", err)
+				}
+
+				if found != test.found || off != test.off {
+					t.Errorf("at %ds expected (%d, %t). Got (%d, %t)", test.sec, test.off, test.found, off, found)
+				}
+			}
+		}
+
+		check(seg)
+
+		if err := seg.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The greatest timestamp and lookups survive a restart.
+		if seg, err = New(dir, 10, c); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			seg.Close()
+		})
+
+		if latest := seg.MaxTimestamp(); !latest.Equal(at(30)) {
+			t.Errorf("expected max timestamp of %v. Got %v", at(30), latest)
+		}
+
+		check(seg)
+
+		// Records without a timestamp are stamped by the segment.
+		before := time.Now().Truncate(time.Millisecond)
+
+		rec := record.Record{}
+		off, err := seg.Append(&rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		read, err := seg.Read(off)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read.Timestamp.Before(before) || !read.Timestamp.Equal(rec.Timestamp) {
+			t.Errorf("expected broker timestamp after %v. Got %v", before, read.Timestamp)
+		}
+	})
 }
@@ -0,0 +1,169 @@
+package segment
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+// The time index is a sparse index used to look up offsets by time. It shares
+// the format of the offset index, holding a timestamp in milliseconds where the
+// offset index holds a position:
+//
+//	[relative offset|timestamp]
+//
+// An entry (o, t) records that t is the greatest timestamp of any record at or
+// before relative offset o. Entries are only written when this maximum grows,
+// so timestamps in the index are increasing even when producers supply them
+// out of order. Every record at or before o is therefore known to be earlier
+// than any time after t.
+
+// millis converts [t] to the millisecond precision used in the time index.
+// Times before the Unix epoch are clamped to it.
+func millis(t time.Time) int64 {
+	return max(t.UnixMilli(), 0)
+}
+
+// indexTime updates the greatest timestamp seen by the segment with those of
+// [records], the batch most recently appended. An entry is added to the time
+// index if the greatest timestamp has grown and at least [Config.TimeInterval]
+// bytes have been written to the store since the previous entry.
+func (s *Segment) indexTime(records []*record.Record) error {
+	maxTimestamp := s.maxTimestamp.Load()
+	for _, rec := range records {
+		maxTimestamp = max(maxTimestamp, millis(rec.Timestamp))
+	}
+
+	s.maxTimestamp.Store(maxTimestamp)
+
+	if s.timeIndex.Entries() > 0 {
+		_, last, err := s.timeIndex.Read(-1)
+		if err != nil {
+			return err
+		}
+
+		if uint64(maxTimestamp) <= last || s.store.Size()-s.timeIndexed < s.Config.TimeInterval {
+			return nil
+		}
+	}
+
+	off := s.NextOffset - 1 - s.BaseOffset
+	if err := s.timeIndex.Write(off, uint64(maxTimestamp)); err != nil {
+		// Lookups remain correct without further entries, only slower.
+		if err == io.EOF {
+			return nil
+		}
+
+		return err
+	}
+
+	s.timeIndexed = s.store.Size()
+
+	return nil
+}
+
+// recoverTimeIndex discards entries in the time index referring to records no
+// longer in the segment and recomputes the greatest timestamp in the segment.
+// Only the records following the last entry need to be read to do so.
+func (s *Segment) recoverTimeIndex() error {
+	n := s.timeIndex.Entries()
+	for ; n > 0; n-- {
+		off, _, err := s.timeIndex.Read(int64(n - 1))
+		if err != nil {
+			return err
+		}
+
+		if s.BaseOffset+uint64(off) < s.NextOffset {
+			break
+		}
+	}
+
+	if err := s.timeIndex.Truncate(n); err != nil {
+		return err
+	}
+
+	var maxTimestamp int64
+
+	from := s.BaseOffset
+	if n > 0 {
+		off, ts, err := s.timeIndex.Read(-1)
+		if err != nil {
+			return err
+		}
+
+		maxTimestamp = int64(ts)
+		from = s.BaseOffset + uint64(off) + 1
+	}
+
+	s.timeIndexed = s.store.Size()
+
+	err := s.Scan(from, func(rec *record.Record) bool {
+		maxTimestamp = max(maxTimestamp, millis(rec.Timestamp))
+
+		return true
+	})
+
+	s.maxTimestamp.Store(maxTimestamp)
+
+	return err
+}
+
+// OffsetForTime returns the offset of the first record in the segment whose
+// timestamp is at or after [t]. If there is no such record, false is returned.
+// Like [Segment.Scan], OffsetForTime only sees records below the watermark, and
+// may be called concurrently with the writer.
+func (s *Segment) OffsetForTime(t time.Time) (uint64, bool, error) {
+	target := millis(t)
+	if s.Watermark() == s.BaseOffset || s.maxTimestamp.Load() < target {
+		return 0, false, nil
+	}
+
+	// Find the first entry at or after [t]. Every record up to and including
+	// the offset of the entry preceding it is earlier than [t], so the scan may
+	// begin immediately after that offset.
+	var err error
+
+	k := sort.Search(int(s.timeIndex.Entries()), func(k int) bool {
+		_, ts, e := s.timeIndex.Read(int64(k))
+		if e != nil {
+			err = e
+		}
+
+		return int64(ts) >= target
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	from := s.BaseOffset
+	if k > 0 {
+		off, _, err := s.timeIndex.Read(int64(k - 1))
+		if err != nil {
+			return 0, false, err
+		}
+
+		from = s.BaseOffset + uint64(off) + 1
+	}
+
+	var (
+		off   uint64
+		found bool
+	)
+	err = s.Scan(from, func(rec *record.Record) bool {
+		if millis(rec.Timestamp) >= target {
+			off, found = rec.Offset, true
+		}
+
+		return !found
+	})
+
+	return off, found, err
+}
+
+// MaxTimestamp returns the greatest timestamp of any record in the segment, or
+// the Unix epoch if the segment is empty.
+func (s *Segment) MaxTimestamp() time.Time {
+	return time.UnixMilli(s.maxTimestamp.Load())
+}
@@ -0,0 +1,100 @@
+package segment
+
+import (
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+// Truncate discards every record in the segment after offset [off], leaving
+// [NextOffset] following the last record kept. If [off] is below [BaseOffset],
+// the segment is emptied. A batch holding records on either side of [off] is
+// rewritten with only the records at or before it.
+//
+// The index is truncated before the store, so a crash part way through leaves
+// entries pointing past the end of the store, which are discarded by [New].
+// Once truncated, the segment is synced.
+func (s *Segment) Truncate(off uint64) error {
+	if off+1 >= s.NextOffset {
+		return nil
+	}
+
+	// The end of the last batch to keep, and the offset following it.
+	end, next := uint64(0), s.BaseOffset
+
+	var partial []*record.Record
+	if off >= s.BaseOffset {
+		pos, err := s.seek(off)
+		if err != nil {
+			return err
+		}
+
+		// Every batch before [pos] is kept.
+		for end = pos; ; end = pos {
+			h, raw, following, err := s.readBatch(pos)
+			if err != nil {
+				return err
+			}
+
+			if h.base > off {
+				break
+			}
+
+			if h.next() <= off+1 {
+				pos, next = following, h.next()
+
+				continue
+			}
+
+			c, err := recordCodec(h.version)
+			if err != nil {
+				return err
+			}
+
+			// Records of a batch have contiguous offsets.
+			partial = make([]*record.Record, off-h.base+1)
+			for i := range partial {
+				if partial[i], raw, err = decodeRecord(c, raw); err != nil {
+					return err
+				}
+			}
+
+			next = h.base
+
+			break
+		}
+	}
+
+	k := s.index.Entries()
+	for ; k > 0; k-- {
+		_, pos, err := s.index.Read(int64(k - 1))
+		if err != nil {
+			return err
+		}
+
+		if pos < end {
+			break
+		}
+	}
+
+	if err := s.index.Truncate(k); err != nil {
+		return err
+	}
+
+	if err := s.store.Truncate(end); err != nil {
+		return err
+	}
+
+	s.NextOffset = next
+	s.watermark.Store(next)
+
+	if err := s.recoverTimeIndex(); err != nil {
+		return err
+	}
+
+	if len(partial) > 0 {
+		if _, err := s.AppendBatch(partial); err != nil {
+			return err
+		}
+	}
+
+	return s.Sync()
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+// Memory is a [Log] held entirely in memory. Offsets begin at 0 and are
+// contiguous, so the offset of a record is its position in the log:
+//
+//	[r0, r1, ... rn][new record]
+//	                ^
+//
+// Nothing survives closing the log, which makes it suited to tests and to
+// data which can be reproduced.
+type Memory struct {
+	mu      sync.RWMutex
+	records []record.Record
+}
+
+// NewMemory returns an empty [Memory] log.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Append implements [Log].
+func (m *Memory) Append(rec *record.Record) (uint64, error) {
+	return m.AppendBatch([]*record.Record{rec})
+}
+
+// AppendBatch implements [Log]. The records are copied along with their keys,
+// values and headers, so later changes to them do not affect the log.
+func (m *Memory) AppendBatch(records []*record.Record) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	base := uint64(len(m.records))
+
+	// Timestamps have the same millisecond precision as those on disk.
+	now := time.UnixMilli(time.Now().UnixMilli())
+	for i, rec := range records {
+		if rec.Timestamp.IsZero() {
+			rec.Timestamp = now
+		}
+
+		rec.Offset = base + uint64(i)
+		m.records = append(m.records, clone(rec))
+	}
+
+	return base, nil
+}
+
+// Read implements [Log].
+func (m *Memory) Read(off uint64) (*record.Record, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if off >= uint64(len(m.records)) {
+		return nil, fmt.Errorf("%w: %d", ErrOutOfBounds, off)
+	}
+
+	rec := clone(&m.records[off])
+
+	return &rec, nil
+}
+
+// Range implements [Log].
+func (m *Memory) Range(from uint64, fn func(*record.Record) bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for off := from; off < uint64(len(m.records)); off++ {
+		rec := clone(&m.records[off])
+		if !fn(&rec) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// LowestOffset implements [Log]. It is always 0.
+func (m *Memory) LowestOffset() uint64 {
+	return 0
+}
+
+// HighestOffset implements [Log].
+func (m *Memory) HighestOffset() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if n := uint64(len(m.records)); n > 0 {
+		return n - 1
+	}
+
+	return 0
+}
+
+// OffsetForTime implements [TimeIndexed]. Timestamps are supplied by producers
+// and may be out of order, so every record is searched.
+func (m *Memory) OffsetForTime(t time.Time) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rec := range m.records {
+		if !rec.Timestamp.Before(t) {
+			return rec.Offset, nil
+		}
+	}
+
+	return 0, ErrTimeOutOfBounds
+}
+
+// Truncate implements [Log].
+func (m *Memory) Truncate(off uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if off+1 < uint64(len(m.records)) {
+		clear(m.records[off+1:])
+		m.records = m.records[:off+1]
+	}
+
+	return nil
+}
+
+// Close implements [Log], discarding every record.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records = nil
+
+	return nil
+}
+
+// clone returns a copy of [rec] sharing no memory with it, so that neither the
+// log nor its callers can change the records held by the other.
+func clone(rec *record.Record) record.Record {
+	c := *rec
+	c.Key = slices.Clone(rec.Key)
+	c.Value = slices.Clone(rec.Value)
+	c.Headers = maps.Clone(rec.Headers)
+
+	return c
+}
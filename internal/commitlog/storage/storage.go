@@ -0,0 +1,99 @@
+// package storage defines the interface shared by the engines backing a commit
+// log, so that code using a log need not know where its records are kept. The
+// disk engine is [log.Log], and [Memory] keeps records in memory.
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+)
+
+var (
+	// ErrOutOfBounds is returned when reading an offset holding no record,
+	// either because it lies outside the log or its record was removed.
+	// Engines may wrap it with details of the offset.
+	ErrOutOfBounds = errors.New("offset out of bounds")
+
+	// ErrBatchTooLarge is returned when a batch cannot be appended as a single
+	// unit.
+	ErrBatchTooLarge = errors.New("batch exceeds segment capacity")
+
+	// ErrTimeOutOfBounds is returned when no record in a log has a timestamp at
+	// or after the requested time.
+	ErrTimeOutOfBounds = errors.New("no record at or after the given time")
+)
+
+// Log is a commit log. Records are assigned increasing offsets as they are
+// appended, and are immutable once appended. Offsets need not be contiguous,
+// e.g. when records are removed by compaction.
+//
+// A Log is safe for concurrent use.
+type Log interface {
+	// Append appends [rec] to the log, assigning and returning its offset.
+	// Records without a timestamp are given the time of the append.
+	Append(rec *record.Record) (uint64, error)
+
+	// AppendBatch appends [records] with contiguous offsets as a single unit,
+	// returning the offset of the first.
+	AppendBatch(records []*record.Record) (uint64, error)
+
+	// Read returns the record at [off], or an error wrapping [ErrOutOfBounds]
+	// if there is none.
+	Read(off uint64) (*record.Record, error)
+
+	// Range calls [fn] with each record in the log in order of offset,
+	// beginning with the first at or after [from], until [fn] returns false.
+	// [fn] must not modify the log.
+	Range(from uint64, fn func(*record.Record) bool) error
+
+	// LowestOffset returns the offset at which the log begins.
+	LowestOffset() uint64
+
+	// HighestOffset returns the offset of the last record in the log, or 0 if
+	// it is empty.
+	HighestOffset() uint64
+
+	// Truncate discards every record after [off]. An error wrapping
+	// [ErrOutOfBounds] is returned if [off] precedes the log.
+	Truncate(off uint64) error
+
+	// Close releases the resources held by the log.
+	Close() error
+}
+
+// Syncer is implemented by logs which must be synced for appended records to
+// survive a crash.
+type Syncer interface {
+	// Sync commits every record appended so far to stable storage.
+	Sync() error
+}
+
+// TimeIndexed is implemented by logs which can look up records by time.
+type TimeIndexed interface {
+	// OffsetForTime returns the offset of the first record whose timestamp is
+	// at or after [t], or [ErrTimeOutOfBounds] if there is none.
+	OffsetForTime(t time.Time) (uint64, error)
+}
+
+// Reencrypter is implemented by logs which encrypt records at rest.
+type Reencrypter interface {
+	// Reencrypt rewrites the records not encrypted under the current key,
+	// returning the number of segments rewritten.
+	Reencrypt() (int, error)
+}
+
+// Partitioned is implemented by sets of logs, called partitions, across which
+// the records of a topic are spread. Partitions are numbered from 0, and
+// records are ordered only within a partition.
+type Partitioned interface {
+	// Partition returns the log of partition [p], reporting whether there is
+	// such a partition.
+	Partition(p int) (Log, bool)
+
+	// PartitionFor returns the partition to which a record with [key] is
+	// routed. Records with the same key are routed to the same partition for as
+	// long as the number of partitions is unchanged.
+	PartitionFor(key []byte) int
+}
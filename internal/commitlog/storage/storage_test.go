@@ -0,0 +1,109 @@
+package storage_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+)
+
+// TestStorage runs the same tests against every engine, since callers of a
+// [storage.Log] may not depend on which one it is.
+func TestStorage(t *testing.T) {
+	engines := map[string]func(t *testing.T) storage.Log{
+		"Memory": func(t *testing.T) storage.Log {
+			return storage.NewMemory()
+		},
+		"Disk": func(t *testing.T) storage.Log {
+			// Segments hold 3 records, so the log spans several of them.
+			l, err := log.New(t.TempDir(), log.Config{
+				Segment: segment.Config{MaxIndexBytes: 12 * 3},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return l
+		},
+	}
+
+	for name, engine := range engines {
+		t.Run(name, func(t *testing.T) {
+			l := engine(t)
+
+			t.Cleanup(func() {
+				l.Close()
+			})
+
+			for i := 0; i < 4; i++ {
+				off, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(i))})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if off != uint64(i) {
+					t.Errorf("expected offset of %d. Got %d", i, off)
+				}
+			}
+
+			batch := []*record.Record{{Value: []byte("4")}, {Value: []byte("5")}}
+			if off, err := l.AppendBatch(batch); err != nil || off != 4 {
+				t.Fatalf("expected batch at offset %d. Got %d (%v)", 4, off, err)
+			}
+
+			// The log keeps its own copy of appended records.
+			batch[0].Value[0] = 'x'
+
+			if rec, err := l.Read(4); err != nil || string(rec.Value) != "4" {
+				t.Errorf("expected record 4 to be unchanged. Got %+v (%v)", rec, err)
+			}
+
+			if low, high := l.LowestOffset(), l.HighestOffset(); low != 0 || high != 5 {
+				t.Errorf("expected offsets [0, 5]. Got [%d, %d]", low, high)
+			}
+
+			rec, err := l.Read(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(rec.Value) != "2" || rec.Timestamp.IsZero() {
+				t.Errorf("expected record 2 with a timestamp. Got %+v", rec)
+			}
+
+			if _, err := l.Read(6); !errors.Is(err, storage.ErrOutOfBounds) {
+				t.Errorf("expected %v. Got %v", storage.ErrOutOfBounds, err)
+			}
+
+			var offsets []uint64
+			err = l.Range(1, func(rec *record.Record) bool {
+				offsets = append(offsets, rec.Offset)
+
+				return rec.Offset < 4
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(offsets) != "[1 2 3 4]" {
+				t.Errorf("expected to range over [1 2 3 4]. Got %v", offsets)
+			}
+
+			if err := l.Truncate(2); err != nil {
+				t.Fatal(err)
+			}
+
+			if high := l.HighestOffset(); high != 2 {
+				t.Errorf("expected highest offset of %d. Got %d", 2, high)
+			}
+
+			if off, err := l.Append(&record.Record{}); err != nil || off != 3 {
+				t.Errorf("expected offset of %d after truncating. Got %d (%v)", 3, off, err)
+			}
+		})
+	}
+}
@@ -4,59 +4,163 @@ package store
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
 	"os"
 	"sync"
+	"sync/atomic"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
 )
 
 // Store represents a data store on disk to which records are written.
+//
+// Writes are buffered, and only become visible to readers once flushed. The
+// number of bytes flushed to the file is published as the committed length,
+// so readers never contend with writers for the lock: they read the file
+// directly, no further than the committed length.
+//
+// Segments flush after every batch, but the buffer still gathers the metadata
+// and contents of each record, and every record of a call to
+// [Store.AppendBatch], into a single write to the file.
+//
+// The file begins with a [header.Header]. Positions in the store are relative
+// to the end of the header.
 type Store struct {
 	*os.File
-	buf  *bufio.Writer
-	mu   sync.Mutex
-	size uint64
+	buf       *bufio.Writer
+	mu        sync.Mutex    // mu serializes writers.
+	size      uint64        // size includes buffered bytes. Guarded by [mu].
+	committed atomic.Uint64 // committed is the number of bytes readers may read.
+	header    header.Header // header is the header at the start of the file.
+	start     int64         // start is the position in the file of the first record.
 }
 
 var (
 	enc = binary.BigEndian
+
+	// Checksums use the Castagnoli polynomial, which most CPUs accelerate.
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
 )
 
 const (
 	// Number of bytes to store the record's length
 	lenWidth = 8
+	// Number of bytes to store the record's checksum
+	crcWidth = 4
+	// Total width of the metadata preceding each record
+	metaWidth = lenWidth + crcWidth
 )
 
-// Create a new store from a [*File].
-func New(file *os.File) (*Store, error) {
+// ErrCorrupt occurs when the record at [Pos] is truncated or its checksum does
+// not match its contents.
+type ErrCorrupt struct {
+	Pos    uint64
+	Reason string
+}
+
+func (e ErrCorrupt) Error() string {
+	return fmt.Sprintf("corrupt record at position %d: %s", e.Pos, e.Reason)
+}
+
+// Create a new store from a [*File]. An empty file is given the header [h],
+// otherwise the file must already begin with a header like [h] (see
+// [header.Init]).
+func New(file *os.File, h header.Header) (*Store, error) {
+	return newStore(file, h, false)
+}
+
+// NewLegacy behaves like [New], except that a file without a header is also
+// accepted. Such a file was written before headers were introduced, and is
+// only opened in order to migrate it.
+func NewLegacy(file *os.File, h header.Header) (*Store, error) {
+	return newStore(file, h, true)
+}
+
+func newStore(file *os.File, h header.Header, legacy bool) (*Store, error) {
+	h.Magic = header.Store
+
+	s := &Store{
+		File: file,
+		buf:  bufio.NewWriter(file),
+	}
+
+	hdr, err := header.Init(file, h)
+	if err != nil && !(legacy && errors.Is(err, header.ErrMagic)) {
+		return nil, err
+	}
+
 	f, err := os.Stat(file.Name())
 	if err != nil {
 		return nil, err
 	}
 
-	size := uint64(f.Size())
+	if hdr.Magic == header.Store {
+		s.header, s.start = hdr, header.Width
+	} else {
+		// The best guess for the creation time of a legacy store is when it was
+		// last written to.
+		s.header = header.Header{Magic: header.Store, BaseOffset: h.BaseOffset, Created: f.ModTime()}
+	}
 
-	return &Store{
-		File: file,
-		size: size,
-		buf:  bufio.NewWriter(file),
-	}, nil
+	s.size = uint64(f.Size() - s.start)
+	s.committed.Store(s.size)
+
+	return s, nil
 }
 
 // Appends persists [p] to the given store [s] returning the length of the
 // record and the position of the bytes in the store.
 //
-// [len(r1)][r1][len(r2)][r2]...[len(rn)][rn]
+// [len(r1)][crc(r1)][r1][len(r2)][crc(r2)][r2]...[len(rn)][crc(rn)][rn]
 //
-// Where each len(ri) block is [lenWidth] bytes in size.
+// Where each len(ri) block is [lenWidth] bytes in size and each crc(ri) block
+// is the [crcWidth] byte CRC32C checksum of ri.
 func (s *Store) Append(p []byte) (uint64, uint64, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.append(p)
+}
+
+// AppendBatch persists each slice in [ps] in order under a single acquisition
+// of the store's lock, so the records occupy a contiguous region. The total
+// length of the records and the position of each is returned.
+func (s *Store) AppendBatch(ps [][]byte) (uint64, []uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total uint64
+
+	positions := make([]uint64, len(ps))
+	for i, p := range ps {
+		length, pos, err := s.append(p)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		total += length
+		positions[i] = pos
+	}
+
+	return total, positions, nil
+}
+
+// append writes a single record to the buffer. The caller must hold the lock.
+func (s *Store) append(p []byte) (uint64, uint64, error) {
 	// The current size of the store is the position of the new record
 	pos := s.size
 
-	// Write the length of the record to the buffer first. This metadata is always
-	// [lenWidth] bytes in length.
-	if err := binary.Write(s.buf, enc, uint64(len(p))); err != nil {
+	// Write the length and checksum of the record to the buffer first. This
+	// metadata is always [metaWidth] bytes in length.
+	var meta [metaWidth]byte
+	enc.PutUint64(meta[:lenWidth], uint64(len(p)))
+	enc.PutUint32(meta[lenWidth:], crc32.Checksum(p, crcTable))
+
+	if _, err := s.buf.Write(meta[:]); err != nil {
 		return 0, 0, err
 	}
 
@@ -65,51 +169,199 @@ func (s *Store) Append(p []byte) (uint64, uint64, error) {
 		return 0, 0, err
 	}
 
-	length := uint64(n + lenWidth)
+	length := uint64(n + metaWidth)
 	s.size += length
 
 	return length, pos, nil
 }
 
-// Read returns the record at [pos] in the store.
+// Read returns the record at [pos] in the store. [io.EOF] is returned if [pos]
+// lies at or beyond the end of the store. If the record is truncated or fails
+// checksum verification, [ErrCorrupt] is returned.
 func (s *Store) Read(pos uint64) ([]byte, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	b, _, err := s.Scan(pos)
 
-	// Transfer bytes from the buffer to disk.
-	if err := s.buf.Flush(); err != nil {
-		return nil, err
+	return b, err
+}
+
+// Scan behaves like [Read], additionally returning the position of the record
+// following the one at [pos]. This allows callers to walk the store from any
+// known record boundary.
+//
+// Only committed bytes are read, so records still in the buffer appear not to
+// exist. Scan does not take the lock and is safe to call concurrently with
+// writers.
+func (s *Store) Scan(pos uint64) ([]byte, uint64, error) {
+	size := s.committed.Load()
+	if pos >= size {
+		return nil, 0, io.EOF
 	}
 
-	// Read the length of the record from the first [lenWidth] bytes after the
-	// offset.
-	length := make([]byte, lenWidth)
-	if _, err := s.File.ReadAt(length, int64(pos)); err != nil {
-		return nil, err
+	if size-pos < metaWidth {
+		return nil, 0, ErrCorrupt{pos, "truncated metadata"}
+	}
+
+	// Read the length and checksum of the record from the first [metaWidth]
+	// bytes after the offset.
+	meta := make([]byte, metaWidth)
+	if _, err := s.File.ReadAt(meta, s.start+int64(pos)); err != nil {
+		return nil, 0, err
+	}
+
+	length := enc.Uint64(meta[:lenWidth])
+	if length > size-pos-metaWidth {
+		return nil, 0, ErrCorrupt{pos, fmt.Sprintf("length %d exceeds store", length)}
 	}
 
 	// Allocate a buffer the length of the record.
-	b := make([]byte, enc.Uint64(length))
+	b := make([]byte, length)
 
 	// Finally, read the actual record contents, skipping past the bytes storing
-	// the record's length
+	// the record's metadata
 	//
-	// [ ... ][ length ][ content ]
-	//        ^pos      ^pos+lenWidth
-	if _, err := s.File.ReadAt(b, int64(pos+lenWidth)); err != nil {
+	// [ ... ][ length ][ crc ][ content ]
+	//        ^pos             ^pos+metaWidth
+	if _, err := s.File.ReadAt(b, s.start+int64(pos+metaWidth)); err != nil {
+		return nil, 0, err
+	}
+
+	if crc := enc.Uint32(meta[lenWidth:]); crc != crc32.Checksum(b, crcTable) {
+		return nil, 0, ErrCorrupt{pos, "checksum mismatch"}
+	}
+
+	return b, pos + metaWidth + length, nil
+}
+
+// ReadAt reads [len(p)] bytes beginning at offset [off] from the store. Like
+// [Store.Scan], only committed bytes are read and the lock is not taken. If
+// fewer than [len(p)] bytes are committed, [io.EOF] is returned.
+func (s *Store) ReadAt(p []byte, off int64) (int, error) {
+	size := int64(s.committed.Load())
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	if rem := size - off; int64(len(p)) > rem {
+		n, err := s.File.ReadAt(p[:rem], s.start+off)
+		if err == nil {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	return s.File.ReadAt(p, s.start+off)
+}
+
+// Decoder reads the records of a store from a stream of its contents, such as
+// one read with [Store.ReadAt].
+type Decoder struct {
+	r   io.Reader
+	pos uint64
+}
+
+// NewDecoder returns a [Decoder] reading the store contents in [r], which must
+// begin at a record boundary.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Next returns the next record in the stream, verifying it like [Store.Read].
+// [io.EOF] is returned once the stream ends between records. If it ends part
+// way through a record, or the record fails checksum verification,
+// [ErrCorrupt] is returned with the record's position in the stream.
+func (d *Decoder) Next() ([]byte, error) {
+	meta := make([]byte, metaWidth)
+	if _, err := io.ReadFull(d.r, meta); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, ErrCorrupt{d.pos, "truncated metadata"}
+		}
+
+		return nil, err
+	}
+
+	// The length is not trusted until the record is verified, so the buffer
+	// grows as the record is read rather than being allocated up front.
+	length := enc.Uint64(meta[:lenWidth])
+
+	b, err := io.ReadAll(io.LimitReader(d.r, int64(min(length, math.MaxInt64))))
+	if err != nil {
 		return nil, err
 	}
 
+	if uint64(len(b)) < length {
+		return nil, ErrCorrupt{d.pos, "truncated record"}
+	}
+
+	if crc := enc.Uint32(meta[lenWidth:]); crc != crc32.Checksum(b, crcTable) {
+		return nil, ErrCorrupt{d.pos, "checksum mismatch"}
+	}
+
+	d.pos += metaWidth + uint64(len(b))
+
 	return b, nil
 }
 
-// ReadAt reads [len(p)] bytes beginning at offset [off] from the store.
-func (s *Store) ReadAt(p []byte, off int64) (int, error) {
+// Flush writes out any buffered bytes, making them visible to readers.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+// flush writes out the buffer and publishes the new committed length. The
+// caller must hold the lock.
+func (s *Store) flush() error {
 	if err := s.buf.Flush(); err != nil {
-		return 0, err
+		return err
+	}
+
+	s.committed.Store(s.size)
+
+	return nil
+}
+
+// Sync writes out any buffered bytes and commits the store's file to stable
+// storage.
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	return s.File.Sync()
+}
+
+// Truncate discards every byte in the store at or beyond [size]. Any buffered
+// bytes are written out first so they are discarded as well.
+func (s *Store) Truncate(size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Buffered bytes which cannot be written out are discarded, so that an
+	// append which failed part way can be undone. Only the committed bytes are
+	// then known to be in the file.
+	if err := s.flush(); err != nil {
+		s.buf.Reset(s.File)
+		s.size = s.committed.Load()
+	}
+
+	// Truncating beyond the end would pad the store with garbage.
+	if size > s.size {
+		return io.EOF
+	}
+
+	if err := s.File.Truncate(s.start + int64(size)); err != nil {
+		return err
 	}
 
-	return s.File.ReadAt(p, off)
+	s.size = size
+	s.committed.Store(size)
+
+	return nil
 }
 
 // Close closes the file descriptor pointing to the store. Any bytes currently
@@ -118,14 +370,26 @@ func (s *Store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := s.buf.Flush(); err != nil {
+	if err := s.flush(); err != nil {
 		return err
 	}
 
 	return s.File.Close()
 }
 
-// Size returns the size of the store in bytes.
+// Size returns the size of the store in bytes, including any buffered bytes.
+// It may only be called by writers.
 func (s *Store) Size() uint64 {
 	return s.size
 }
+
+// Header returns the header at the start of the store's file. If the file
+// predates headers (see [NewLegacy]), a header with version 0 is returned.
+func (s *Store) Header() header.Header {
+	return s.header
+}
+
+// Committed returns the number of bytes in the store visible to readers.
+func (s *Store) Committed() uint64 {
+	return s.committed.Load()
+}
@@ -1,10 +1,15 @@
 package store
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/header"
 )
 
 func TestStore(t *testing.T) {
@@ -15,7 +20,7 @@ func TestStore(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			store, err := New(tmp)
+			store, err := New(tmp, header.Header{})
 			if err != nil {
 				t.Fatalf("error creating store: %v", err)
 			}
@@ -48,7 +53,7 @@ func TestStore(t *testing.T) {
 			}
 
 			// Expected length is the length of the bytes plus the metadata block.
-			if expected := uint64(len(d)) + lenWidth; length != expected {
+			if expected := uint64(len(d)) + metaWidth; length != expected {
 				t.Errorf("Expected record length of %d. Got: %d", expected, length)
 			}
 
@@ -64,6 +69,43 @@ func TestStore(t *testing.T) {
 		}
 	})
 
+	run("AppendBatch", func(store *Store, t *testing.T) {
+		data := [][]byte{
+			[]byte("one"),
+			[]byte("two"),
+			[]byte("three"),
+		}
+
+		total, positions, err := store.AppendBatch(data)
+		if err != nil {
+			t.Fatalf("error appending batch: %v", err)
+		}
+
+		// Records only become visible to readers once flushed.
+		if _, err := store.Read(positions[0]); err != io.EOF {
+			t.Errorf("expected %v reading unflushed record. Got %v", io.EOF, err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if total != store.Size() {
+			t.Errorf("expected batch length of %d. Got %d", store.Size(), total)
+		}
+
+		for i, pos := range positions {
+			record, err := store.Read(pos)
+			if err != nil {
+				t.Fatalf("error reading record: %v", err)
+			}
+
+			if !bytes.Equal(record, data[i]) {
+				t.Errorf("expected record to equal %s. Got: %s", data[i], record)
+			}
+		}
+	})
+
 	// This test suite is interesting in that it uses Append in order to set up the
 	// test file for Read operations. The jury is still out on whether this is poor
 	// test design or not.
@@ -81,6 +123,10 @@ func TestStore(t *testing.T) {
 				t.Errorf("error appending record: %v", err)
 			}
 
+			if err := store.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
 			record, err := store.Read(pos)
 			if err != nil && err != io.EOF {
 				t.Errorf("error reading record: %v", err)
@@ -100,6 +146,148 @@ func TestStore(t *testing.T) {
 		}
 	})
 
+	run("Corrupt", func(store *Store, t *testing.T) {
+		data := []byte("some important data")
+
+		_, pos, err := store.Append(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Flip a bit in the record contents behind the store's back.
+		b := []byte{0}
+		if _, err := store.File.ReadAt(b, store.start+int64(pos+metaWidth)); err != nil {
+			t.Fatal(err)
+		}
+
+		b[0] ^= 1
+		if _, err := store.File.WriteAt(b, store.start+int64(pos+metaWidth)); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.Read(pos)
+
+		var corrupt ErrCorrupt
+		if !errors.As(err, &corrupt) {
+			t.Fatalf("expected ErrCorrupt. Got: %v", err)
+		}
+
+		if corrupt.Pos != pos {
+			t.Errorf("expected corruption at position %d. Got %d", pos, corrupt.Pos)
+		}
+	})
+
+	run("Decoder", func(store *Store, t *testing.T) {
+		data := [][]byte{[]byte("first"), {}, []byte("third")}
+		if _, _, err := store.AppendBatch(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		contents := io.NewSectionReader(store, 0, int64(store.Committed()))
+
+		dec := NewDecoder(contents)
+		for _, expected := range data {
+			b, err := dec.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, expected) {
+				t.Errorf("expected %s. Got %s", expected, b)
+			}
+		}
+
+		if _, err := dec.Next(); err != io.EOF {
+			t.Errorf("expected EOF. Got %v", err)
+		}
+
+		// The final record is cut short.
+		dec = NewDecoder(io.NewSectionReader(store, 0, int64(store.Committed())-1))
+		for range data[:2] {
+			if _, err := dec.Next(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var corrupt ErrCorrupt
+		if _, err := dec.Next(); !errors.As(err, &corrupt) || corrupt.Pos != 2*metaWidth+5 {
+			t.Errorf("expected ErrCorrupt at %d. Got %v", 2*metaWidth+5, err)
+		}
+	})
+
+	run("Truncated", func(store *Store, t *testing.T) {
+		_, pos, err := store.Append([]byte("torn write"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Pretend only part of the record made it to disk.
+		if err := store.Truncate(pos + metaWidth + 2); err != nil {
+			t.Fatal(err)
+		}
+
+		var corrupt ErrCorrupt
+		if _, err := store.Read(pos); !errors.As(err, &corrupt) {
+			t.Errorf("expected ErrCorrupt. Got: %v", err)
+		}
+	})
+
+	run("FailedFlush", func(store *Store, t *testing.T) {
+		if _, _, err := store.Append([]byte("kept")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		size := store.Size()
+
+		// The buffered record cannot be written out.
+		store.buf = bufio.NewWriter(failingWriter{})
+		if _, _, err := store.Append([]byte("lost")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err == nil {
+			t.Fatal("expected error flushing")
+		}
+
+		// Truncating discards the buffered record and recovers the store.
+		if err := store.Truncate(size); err != nil {
+			t.Fatal(err)
+		}
+
+		_, pos, err := store.Append([]byte("next"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		if pos != size {
+			t.Errorf("expected position %d. Got %d", size, pos)
+		}
+
+		if b, err := store.Read(pos); err != nil || string(b) != "next" {
+			t.Errorf("expected record %q. Got %q (%v)", "next", b, err)
+		}
+	})
+
 	// "Recovery" in this sense is the ability to 'recreate' a store destroyed by
 	// some failure.
 	t.Run("Recovery", func(t *testing.T) {
@@ -112,7 +300,7 @@ func TestStore(t *testing.T) {
 		}
 
 		// Create a store and append like normal.
-		store, err := New(tmp)
+		store, err := New(tmp, header.Header{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -127,15 +315,15 @@ func TestStore(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		// NOTE: A store 'naturally' flushes on Read, meaning appends without a
-		// corresponding read or manual buffer flush will appear to be incorrect!
-		if err := store.buf.Flush(); err != nil {
+		// NOTE: Appends are buffered, so without a flush they will appear to be
+		// missing!
+		if err := store.Flush(); err != nil {
 			t.Fatal(err)
 		}
 
 		// Create a new store pointing to the same file on disk. This represents an
 		// attempt at recovery.
-		store, err = New(tmp)
+		store, err = New(tmp, header.Header{})
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -149,4 +337,83 @@ func TestStore(t *testing.T) {
 			t.Errorf("expected %s from recovery store. Got %s", data, record)
 		}
 	})
+
+	t.Run("Header", func(t *testing.T) {
+		tmp, err := os.CreateTemp("", "test_header")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			os.Remove(tmp.Name())
+		})
+
+		h := header.Header{Version: header.Version, BaseOffset: 16, Created: time.UnixMilli(1000)}
+
+		store, err := New(tmp, h)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := store.Append([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Reopening the store must return the header originally written rather
+		// than the one given.
+		store, err = New(tmp, header.Header{BaseOffset: 16, Created: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if got := store.Header(); !got.Created.Equal(h.Created) || got.Magic != header.Store {
+			t.Errorf("expected header %+v. Got %+v", h, got)
+		}
+
+		if _, err := New(tmp, header.Header{BaseOffset: 32}); err == nil {
+			t.Errorf("expected error opening store with the wrong base offset")
+		}
+	})
+
+	t.Run("Foreign", func(t *testing.T) {
+		tmp, err := os.CreateTemp("", "test_foreign")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			os.Remove(tmp.Name())
+			tmp.Close()
+		})
+
+		if _, err := tmp.WriteString("this is not a store"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := New(tmp, header.Header{}); !errors.Is(err, header.ErrMagic) {
+			t.Errorf("expected ErrMagic. Got: %v", err)
+		}
+
+		// A legacy store without a header is read from the start of the file.
+		store, err := NewLegacy(tmp, header.Header{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if store.Size() != 19 {
+			t.Errorf("expected legacy store of size 19. Got %d", store.Size())
+		}
+	})
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
 }
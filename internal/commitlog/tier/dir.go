@@ -0,0 +1,123 @@
+package tier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tmpPrefix begins the names of objects still being written by [Dir.Put].
+const tmpPrefix = ".put"
+
+// Dir is an [ObjectStore] keeping each object as a file in a directory. It
+// stands in for a remote object store, e.g. on a network file system or in
+// tests.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a [Dir] storing objects under [root], which is created if it
+// does not already exist.
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Dir{root}, nil
+}
+
+// path returns the path of the file holding the object [name].
+func (d *Dir) path(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, tmpPrefix) {
+		return "", fmt.Errorf("invalid object name: %q", name)
+	}
+
+	return filepath.Join(d.root, name), nil
+}
+
+// Put implements [ObjectStore]. The object is written to a temporary file and
+// then renamed into place.
+func (d *Dir) Put(name string, r io.Reader) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(d.root, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Get implements [ObjectStore].
+func (d *Dir) Get(name string) (io.ReadCloser, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	return f, err
+}
+
+// Delete implements [ObjectStore].
+func (d *Dir) Delete(name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// List implements [ObjectStore].
+func (d *Dir) List(prefix string) ([]string, error) {
+	files, err := os.ReadDir(d.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, tmpPrefix) || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	// ReadDir returns the files sorted by name.
+	return names, nil
+}
@@ -0,0 +1,49 @@
+package tier
+
+import (
+	"io"
+	"strings"
+)
+
+// prefixed is an [ObjectStore] keeping its objects in another under names
+// beginning with a fixed prefix.
+type prefixed struct {
+	store  ObjectStore
+	prefix string
+}
+
+// Prefix returns an [ObjectStore] keeping its objects in [store], with [prefix]
+// prepended to their names. Several logs may then share one object store, as
+// long as no prefix begins another.
+func Prefix(store ObjectStore, prefix string) ObjectStore {
+	return prefixed{store, prefix}
+}
+
+// Put implements [ObjectStore].
+func (p prefixed) Put(name string, r io.Reader) error {
+	return p.store.Put(p.prefix+name, r)
+}
+
+// Get implements [ObjectStore].
+func (p prefixed) Get(name string) (io.ReadCloser, error) {
+	return p.store.Get(p.prefix + name)
+}
+
+// Delete implements [ObjectStore].
+func (p prefixed) Delete(name string) error {
+	return p.store.Delete(p.prefix + name)
+}
+
+// List implements [ObjectStore]. The names returned are without the prefix.
+func (p prefixed) List(prefix string) ([]string, error) {
+	names, err := p.store.List(p.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, p.prefix)
+	}
+
+	return names, nil
+}
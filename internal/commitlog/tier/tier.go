@@ -0,0 +1,32 @@
+// package tier defines the object stores to which sealed segments are offloaded
+// once they are too old to be kept on local disk. An object store only needs
+// to hold named blobs, so it may be backed by anything from a local directory
+// (see [Dir]) to an S3-compatible service.
+package tier
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ObjectStore holds immutable objects by name. Names never contain a path
+// separator.
+type ObjectStore interface {
+	// Put stores the contents of [r] as the object [name], replacing any
+	// existing object. The object must not be visible until it is complete.
+	Put(name string, r io.Reader) error
+
+	// Get returns a reader of the object [name], or an error wrapping
+	// [ErrNotFound] if there is none. The caller must close the reader.
+	Get(name string) (io.ReadCloser, error)
+
+	// Delete removes the object [name]. Deleting an object which does not
+	// exist is not an error.
+	Delete(name string) error
+
+	// List returns the names of every object beginning with [prefix], sorted.
+	List(prefix string) ([]string, error)
+}
@@ -0,0 +1,109 @@
+package tier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	d, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"3.store", "0.meta", "0.store"} {
+		if err := d.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatalf("error putting %s: %v", name, err)
+		}
+	}
+
+	// Putting an existing object replaces it.
+	if err := d.Put("0.store", strings.NewReader("replaced")); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := d.Get("0.store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := io.ReadAll(obj)
+	obj.Close()
+
+	if err != nil || string(b) != "replaced" {
+		t.Errorf("expected %q. Got %q (%v)", "replaced", b, err)
+	}
+
+	tests := map[string]string{
+		"":   "[0.meta 0.store 3.store]",
+		"0.": "[0.meta 0.store]",
+		"4.": "[]",
+	}
+
+	for prefix, expected := range tests {
+		names, err := d.List(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(names) != expected {
+			t.Errorf("expected %s listing %q. Got %v", expected, prefix, names)
+		}
+	}
+
+	if err := d.Delete("0.store"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a missing object is not an error.
+	if err := d.Delete("0.store"); err != nil {
+		t.Errorf("error deleting missing object: %v", err)
+	}
+
+	if _, err := d.Get("0.store"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v. Got %v", ErrNotFound, err)
+	}
+
+	for _, name := range []string{"", "a/b", "../0.store", tmpPrefix + "1"} {
+		if err := d.Put(name, strings.NewReader("")); err == nil {
+			t.Errorf("expected error putting %q", name)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	d, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := Prefix(d, "a."), Prefix(d, "b.")
+	for _, name := range []string{"0.store", "3.store"} {
+		if err := a.Put(name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Put("0.store", strings.NewReader("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, err := a.List(""); err != nil || fmt.Sprint(names) != "[0.store 3.store]" {
+		t.Errorf("expected [0.store 3.store]. Got %v (%v)", names, err)
+	}
+
+	if names, err := d.List(""); err != nil || fmt.Sprint(names) != "[a.0.store a.3.store b.0.store]" {
+		t.Errorf("expected objects to be prefixed. Got %v (%v)", names, err)
+	}
+
+	if err := b.Delete("0.store"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Get("0.store"); err != nil {
+		t.Errorf("expected object to be kept: %v", err)
+	}
+}
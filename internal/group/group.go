@@ -0,0 +1,257 @@
+// package group keeps the offsets committed by consumer groups, so that
+// consumers can resume from where their group left off. Offsets are kept in an
+// internal commit log as records keyed by group, topic and partition, so the
+// log can be compacted down to the newest offset of each.
+package group
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+)
+
+// Dir is the name of the directory under the data directory holding the log of
+// committed offsets. It is not a valid topic name, so it is never mistaken for
+// a topic.
+const Dir = ".groups"
+
+var (
+	// ErrInvalidName is returned when committing offsets for a group whose name
+	// is not allowed (see [validName]).
+	ErrInvalidName = errors.New("invalid group name")
+
+	// validName matches the names allowed for groups.
+	validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+)
+
+// Offset is the position of a group in a partition of a topic. By convention
+// it is the offset of the next record to consume, i.e. one past the last
+// record processed.
+type Offset struct {
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+	Offset    uint64 `json:"offset"`
+}
+
+// key identifies a committed offset, and is the key of the records holding it.
+type key struct {
+	Group     string `json:"group"`
+	Topic     string `json:"topic"`
+	Partition int    `json:"partition"`
+}
+
+// value is the value of a record holding a committed offset.
+type value struct {
+	Offset uint64 `json:"offset"`
+}
+
+// Store holds the offsets committed by every group.
+type Store struct {
+	log storage.Log
+
+	mu      sync.RWMutex
+	offsets map[key]uint64
+}
+
+// Open returns a store keeping offsets in [l], and reads any offsets committed
+// to it previously. The newest record for each key holds its offset, and a
+// tombstone removes it.
+func Open(l storage.Log) (*Store, error) {
+	s := Store{log: l, offsets: make(map[key]uint64)}
+
+	err := l.Range(l.LowestOffset(), func(rec *record.Record) bool {
+		var k key
+		if err := json.Unmarshal(rec.Key, &k); err != nil {
+			return true
+		}
+
+		if rec.IsTombstone() {
+			delete(s.offsets, k)
+
+			return true
+		}
+
+		var v value
+		if err := json.Unmarshal(rec.Value, &v); err == nil {
+			s.offsets[k] = v.Offset
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Commit commits [offsets] for [group] as a single unit, superseding any
+// offsets it committed previously for the same partitions. The offsets are
+// synced to stable storage before returning, if the log requires it.
+func (s *Store) Commit(group string, offsets []Offset) error {
+	if !validName.MatchString(group) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, group)
+	}
+
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	records := make([]*record.Record, len(offsets))
+	for i, o := range offsets {
+		k, err := json.Marshal(key{group, o.Topic, o.Partition})
+		if err != nil {
+			return err
+		}
+
+		v, err := json.Marshal(value{o.Offset})
+		if err != nil {
+			return err
+		}
+
+		records[i] = &record.Record{Key: k, Value: v}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.log.AppendBatch(records); err != nil {
+		return err
+	}
+
+	if syncer, ok := s.log.(storage.Syncer); ok {
+		if err := syncer.Sync(); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range offsets {
+		s.offsets[key{group, o.Topic, o.Partition}] = o.Offset
+	}
+
+	return nil
+}
+
+// DeleteTopic removes the offsets committed by every group for [topic], which
+// is about to be deleted, so that a topic recreated under the same name is
+// consumed from its start.
+func (s *Store) DeleteTopic(topic string) error {
+	return s.remove(func(k key) bool {
+		return k.Topic == topic
+	})
+}
+
+// Prune removes the offsets committed for every topic for which [exists]
+// returns false. This catches the offsets of a topic deleted while its
+// offsets were being committed, or before they could be removed.
+func (s *Store) Prune(exists func(topic string) bool) error {
+	return s.remove(func(k key) bool {
+		return !exists(k.Topic)
+	})
+}
+
+// remove removes every offset whose key satisfies [match]. A tombstone is
+// appended for each offset, as a single unit, and synced if the log requires
+// it.
+func (s *Store) remove(match func(key) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		keys    []key
+		records []*record.Record
+	)
+	for k := range s.offsets {
+		if !match(k) {
+			continue
+		}
+
+		b, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, k)
+		records = append(records, &record.Record{Key: b})
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	if _, err := s.log.AppendBatch(records); err != nil {
+		return err
+	}
+
+	if syncer, ok := s.log.(storage.Syncer); ok {
+		if err := syncer.Sync(); err != nil {
+			return err
+		}
+	}
+
+	for _, k := range keys {
+		delete(s.offsets, k)
+	}
+
+	return nil
+}
+
+// Committed returns the offset committed by [group] for [partition] of
+// [topic], reporting whether there is one.
+func (s *Store) Committed(group, topic string, partition int) (uint64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	off, ok := s.offsets[key{group, topic, partition}]
+
+	return off, ok
+}
+
+// Offsets returns every offset committed by [group], sorted by topic and then
+// by partition.
+func (s *Store) Offsets(group string) []Offset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	offsets := []Offset{}
+	for k, off := range s.offsets {
+		if k.Group == group {
+			offsets = append(offsets, Offset{k.Topic, k.Partition, off})
+		}
+	}
+
+	slices.SortFunc(offsets, func(a, b Offset) int {
+		if c := strings.Compare(a.Topic, b.Topic); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.Partition, b.Partition)
+	})
+
+	return offsets
+}
+
+// Reencrypt implements [storage.Reencrypter], reencrypting the log holding the
+// offsets if it supports it.
+func (s *Store) Reencrypt() (int, error) {
+	e, ok := s.log.(storage.Reencrypter)
+	if !ok {
+		return 0, nil
+	}
+
+	return e.Reencrypt()
+}
+
+// Close closes the log holding the offsets.
+func (s *Store) Close() error {
+	return s.log.Close()
+}
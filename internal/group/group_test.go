@@ -0,0 +1,115 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+)
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := log.New(dir, log.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Committed("billing", "orders", 0); ok {
+		t.Errorf("expected no committed offset")
+	}
+
+	commits := []struct {
+		group   string
+		offsets []Offset
+	}{
+		{"billing", []Offset{{"orders", 1, 10}, {"orders", 0, 5}}},
+		{"shipping", []Offset{{"orders", 0, 3}}},
+		{"billing", []Offset{{"orders", 0, 7}}},
+	}
+
+	for _, c := range commits {
+		if err := s.Commit(c.group, c.offsets); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Commit("a.b", []Offset{{"orders", 0, 1}}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected %v. Got %v", ErrInvalidName, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Committed offsets are read back from the log.
+	if l, err = log.New(dir, log.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = Open(l); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	if off, ok := s.Committed("billing", "orders", 0); !ok || off != 7 {
+		t.Errorf("expected committed offset %d. Got %d (%t)", 7, off, ok)
+	}
+
+	expected := "[{orders 0 7} {orders 1 10}]"
+	if offsets := fmt.Sprint(s.Offsets("billing")); offsets != expected {
+		t.Errorf("expected offsets %s. Got %s", expected, offsets)
+	}
+
+	if offsets := s.Offsets("unknown"); len(offsets) != 0 {
+		t.Errorf("expected no offsets. Got %v", offsets)
+	}
+
+	// Deleting a topic deletes its offsets for every group, including once the
+	// store is reopened.
+	if err := s.Commit("billing", []Offset{{"payments", 0, 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteTopic("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l, err = log.New(dir, log.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = Open(l); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Committed("shipping", "orders", 0); ok {
+		t.Errorf("expected offset of deleted topic to be removed")
+	}
+
+	if offsets := fmt.Sprint(s.Offsets("billing")); offsets != "[{payments 0 2}]" {
+		t.Errorf("expected offsets [{payments 0 2}]. Got %s", offsets)
+	}
+
+	// Offsets of topics which no longer exist are pruned.
+	if err := s.Prune(func(topic string) bool { return topic != "payments" }); err != nil {
+		t.Fatal(err)
+	}
+
+	if offsets := s.Offsets("billing"); len(offsets) != 0 {
+		t.Errorf("expected no offsets. Got %v", offsets)
+	}
+}
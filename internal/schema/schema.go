@@ -1,12 +1,15 @@
 // package schema provides avro codecs for schemas located under the package's
 // directory structure. Each codec corresponds to a constant defined in the
-// package. Codecs are lazily and idempotently initialized.
+// package. Codecs are lazily and idempotently initialized, and are safe for
+// concurrent use.
 package schema
 
 import (
 	_ "embed"
 
+	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/linkedin/goavro"
 )
@@ -15,19 +18,41 @@ import (
 type CODEC uint8
 
 const (
-	RECORD CODEC = iota
+	RECORD    CODEC = iota // RECORD is the current schema of a commit log record.
+	RECORD_V0              // RECORD_V0 is the original record schema, holding an offset and a value.
+	RECORD_V1              // RECORD_V1 is the record schema after timestamps were added.
+	RECORD_V2              // RECORD_V2 is the record schema after keys were added and values made nullable.
 )
 
 var (
 	//go:embed commitlog/record.json
 	record string
 
+	//go:embed commitlog/record_v0.json
+	recordV0 string
+
+	//go:embed commitlog/record_v1.json
+	recordV1 string
+
+	//go:embed commitlog/record_v2.json
+	recordV2 string
+
+	// mu guards [Lookup] and [defaults].
+	mu sync.Mutex
+
 	// Lookup associates a constant value representing a schema with the correct
-	// avro codec.
+	// avro codec. It must not be accessed directly (use [GetCodec]).
 	Lookup = make(map[CODEC]*goavro.Codec)
+
+	// defaults associates a schema with the native default values of its fields
+	// (see [Resolve]).
+	defaults = make(map[CODEC]map[string]interface{})
 )
 
 func getCodec(c CODEC, schema string) (*goavro.Codec, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	codec, ok := Lookup[c]
 	if ok {
 		return codec, nil
@@ -47,10 +72,122 @@ func getCodec(c CODEC, schema string) (*goavro.Codec, error) {
 // GetCodec retrieves the codec specified by [c]. The codec will be initialized
 // only on the first call to GetCodec; subsequent invocations are idempotent.
 func GetCodec(c CODEC) (*goavro.Codec, error) {
+	schema, err := source(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return getCodec(c, schema)
+}
+
+// source returns the JSON specification of the schema [c].
+func source(c CODEC) (string, error) {
 	switch c {
 	case RECORD:
-		return getCodec(c, record)
+		return record, nil
+	case RECORD_V0:
+		return recordV0, nil
+	case RECORD_V1:
+		return recordV1, nil
+	case RECORD_V2:
+		return recordV2, nil
 	default:
-		return nil, fmt.Errorf("codec not found")
+		return "", fmt.Errorf("codec not found")
 	}
 }
+
+// Resolve converts [datum], a record decoded with an older or newer version of
+// the record schema [reader], to that schema. This follows the Avro rules for
+// schema resolution: fields unknown to the reader are ignored and fields
+// unknown to the writer take their default value. A field without a default
+// missing from [datum] is an error.
+//
+// NOTE: goavro can only decode data with the schema it was written with, hence
+// this is done by hand. Only top-level fields of records are resolved.
+func Resolve(datum map[string]interface{}, reader CODEC) (map[string]interface{}, error) {
+	fields, err := getDefaults(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]interface{}, len(fields))
+	for name, def := range fields {
+		if v, ok := datum[name]; ok {
+			resolved[name] = v
+
+			continue
+		}
+
+		if def == noDefault {
+			return nil, fmt.Errorf("field %q has no value and no default", name)
+		}
+
+		resolved[name] = def
+	}
+
+	return resolved, nil
+}
+
+// noDefault marks fields without a default value.
+var noDefault = new(struct{})
+
+// getDefaults returns the native default value of each field in the record
+// schema [c]. An Avro default is given in JSON, and a union's default has the
+// type of its first branch, so each is decoded with a codec for that type.
+func getDefaults(c CODEC) (map[string]interface{}, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if fields, ok := defaults[c]; ok {
+		return fields, nil
+	}
+
+	schema, err := source(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec struct {
+		Fields []struct {
+			Name    string          `json:"name"`
+			Type    json.RawMessage `json:"type"`
+			Default json.RawMessage `json:"default"` // Default holds "null" for a null default.
+		} `json:"fields"`
+	}
+
+	if err := json.Unmarshal([]byte(schema), &spec); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{}, len(spec.Fields))
+	for _, f := range spec.Fields {
+		if len(f.Default) == 0 {
+			fields[f.Name] = noDefault
+
+			continue
+		}
+
+		typ := f.Type
+
+		var union []json.RawMessage
+		if json.Unmarshal(typ, &union) == nil && len(union) > 0 {
+			typ = union[0]
+		}
+
+		fc, err := goavro.NewCodec(string(typ))
+		if err != nil {
+			return nil, err
+		}
+
+		def, _, err := fc.NativeFromTextual(f.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default of field %q: %w", f.Name, err)
+		}
+
+		fields[f.Name] = def
+	}
+
+	defaults[c] = fields
+
+	return fields, nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 // Allow users to provide input and output types to support more "go-like" HTTP
@@ -90,12 +91,13 @@ func handleRequest[Req any, Res any](req Req, w http.ResponseWriter, r *http.Req
 func shutdown() <-chan struct{} {
 	// Channel to block until idle connections are closed.
 	conns := make(chan struct{})
-	defer close(conns)
 
-	// This goroutine blocks until receiving SIGINT
+	// This goroutine blocks until receiving SIGINT or SIGTERM
 	go func() {
+		defer close(conns)
+
 		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
+		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
 
 		<-sigint
 
@@ -112,7 +114,8 @@ func Shutdown() {
 	}
 }
 
-// Run starts the HTTP server and does not return except on a fatal error.
+// Run starts the HTTP server and blocks until the server has been gracefully
+// shut down. Any other error is fatal.
 func Run() {
 	cxn := shutdown()
 
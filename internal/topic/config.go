@@ -0,0 +1,101 @@
+package topic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+)
+
+// metaFile is the name of the file in a topic's directory holding its
+// [Config].
+const metaFile = "topic.json"
+
+// Config is the configuration of a single topic, overriding that shared by
+// every topic in a [Registry]. Zero fields are not overridden.
+//
+// [Partitions] is specific to topics, and defaults to 1.
+type Config struct {
+	Partitions     int    `json:"partitions,omitempty"`     // Partitions is the number of logs across which records are spread.
+	MaxStoreBytes  uint64 `json:"maxStoreBytes,omitempty"`  // MaxStoreBytes is the size of a segment's store beyond which it is rolled.
+	MaxIndexBytes  uint64 `json:"maxIndexBytes,omitempty"`  // MaxIndexBytes is the size of a segment's index beyond which it is rolled.
+	RetentionMs    int64  `json:"retentionMs,omitempty"`    // RetentionMs is the age in milliseconds beyond which segments are deleted.
+	RetentionBytes uint64 `json:"retentionBytes,omitempty"` // RetentionBytes is the size of the log beyond which segments are deleted.
+}
+
+// validate reports whether [c] is a valid configuration.
+func (c Config) validate() error {
+	if c.Partitions < 0 {
+		return fmt.Errorf("%w: partitions must not be negative", ErrInvalidConfig)
+	}
+
+	if c.RetentionMs < 0 {
+		return fmt.Errorf("%w: retentionMs must not be negative", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// withDefaults returns [c] with its unset fields specific to topics set to
+// their defaults.
+func (c Config) withDefaults() Config {
+	c.Partitions = max(c.Partitions, 1)
+
+	return c
+}
+
+// apply returns [base] with the fields set in [c] overridden.
+func (c Config) apply(base log.Config) log.Config {
+	if c.MaxStoreBytes > 0 {
+		base.Segment.MaxStoreBytes = c.MaxStoreBytes
+	}
+
+	if c.MaxIndexBytes > 0 {
+		base.Segment.MaxIndexBytes = c.MaxIndexBytes
+	}
+
+	if c.RetentionMs > 0 {
+		base.Retention.MaxAge = time.Duration(c.RetentionMs) * time.Millisecond
+	}
+
+	if c.RetentionBytes > 0 {
+		base.Retention.MaxBytes = c.RetentionBytes
+	}
+
+	return base
+}
+
+// readConfig reads the configuration of the topic in [dir].
+func readConfig(dir string) (Config, error) {
+	b, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if err != nil {
+		return Config{}, err
+	}
+
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Config{}, err
+	}
+
+	return c.withDefaults(), c.validate()
+}
+
+// writeConfig writes [c] as the configuration of the topic in [dir]. The file
+// is written under a temporary name and renamed into place, so that a crash
+// never leaves a topic with a partially written configuration.
+func writeConfig(dir string, c Config) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(dir, metaFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, metaFile))
+}
@@ -0,0 +1,94 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/beautifultovarisch/dlog/internal/server"
+)
+
+// Handle adapts a handler made by [fn] into one serving the topic named by the
+// {topic} path parameter. If the route has a {partition} parameter, [fn] is
+// given the log of that partition, and otherwise the topic itself, which
+// implements [storage.Partitioned]. Either must implement [L], e.g.
+// [storage.TimeIndexed] for lookups by time, and the topic cannot be deleted or
+// repartitioned while the request is served.
+//
+// A route without a {partition} parameter whose handler cannot be given the
+// topic is served by partition 0 instead, so routes predating partitions keep
+// working. This is only done for a topic with a single partition: records
+// produced to a topic without naming a partition are spread across all of
+// them, so partition 0 alone would only hold some of them. Such requests are
+// rejected instead, and must name a partition.
+//
+// Example:
+//
+//	server.Route("GET /topics/{topic}/partitions/{partition}/consume/{offset}", topic.Handle(r, consume.Consume))
+//	server.Route("GET /topics/{topic}/consume/{offset}", topic.Handle(r, consume.Consume))
+func Handle[L any, Req any, Res any](r *Registry, fn func(L) server.Handler[Req, Res]) server.Handler[Req, Res] {
+	return func(req Req, w http.ResponseWriter, hr *http.Request) (*Res, error) {
+		t, err := r.Acquire(hr.PathValue("topic"))
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+			}
+
+			return nil, err
+		}
+		defer t.Release()
+
+		var target any = t
+		if partition := hr.PathValue("partition"); partition != "" {
+			p, err := strconv.Atoi(partition)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return nil, fmt.Errorf("invalid partition: %s", partition)
+			}
+
+			l, ok := t.Partition(p)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+
+				return nil, fmt.Errorf("topic %s has no partition %d", t.Name, p)
+			}
+
+			target = l
+		} else if _, ok := target.(L); !ok {
+			if n := t.Config().Partitions; n > 1 {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return nil, fmt.Errorf("topic %s has %d partitions, so one must be named", t.Name, n)
+			}
+
+			target, _ = t.Partition(0)
+		}
+
+		l, ok := target.(L)
+		if !ok {
+			w.WriteHeader(http.StatusNotImplemented)
+
+			return nil, fmt.Errorf("topic %s does not support this operation", t.Name)
+		}
+
+		return fn(l)(req, w, hr)
+	}
+}
+
+// HandleDefault is [Handle] for the routes predating topics, which are served
+// by [DefaultTopic] as if they named it in their {topic} parameter.
+//
+// Example:
+//
+//	server.Route("GET /consume/{offset}", topic.HandleDefault(r, consume.Consume))
+func HandleDefault[L any, Req any, Res any](r *Registry, fn func(L) server.Handler[Req, Res]) server.Handler[Req, Res] {
+	h := Handle(r, fn)
+
+	return func(req Req, w http.ResponseWriter, hr *http.Request) (*Res, error) {
+		hr.SetPathValue("topic", DefaultTopic)
+
+		return h(req, w, hr)
+	}
+}
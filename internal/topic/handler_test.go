@@ -0,0 +1,139 @@
+package topic
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beautifultovarisch/dlog/internal/server"
+
+	"github.com/beautifultovarisch/dlog/internal/api/consume"
+	"github.com/beautifultovarisch/dlog/internal/api/offsets"
+	"github.com/beautifultovarisch/dlog/internal/api/produce"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+)
+
+func TestHandle(t *testing.T) {
+	r, err := Open(t.TempDir(), log.Config{}, Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		r.Close()
+	})
+
+	if _, err := r.Create("single", Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Create("multi", Config{Partitions: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler serves a single log, so it cannot be given a topic.
+	h := Handle(r, func(l storage.Log) server.Handler[struct{}, uint64] {
+		return func(struct{}, http.ResponseWriter, *http.Request) (*uint64, error) {
+			off := l.HighestOffset()
+
+			return &off, nil
+		}
+	})
+
+	tests := []struct {
+		topic, partition string
+		status           int
+	}{
+		{"single", "0", http.StatusOK},
+		{"multi", "1", http.StatusOK},
+		{"multi", "2", http.StatusNotFound},
+		{"missing", "0", http.StatusNotFound},
+		// Routes without a partition are served by the only partition.
+		{"single", "", http.StatusOK},
+		{"multi", "", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetPathValue("topic", test.topic)
+		req.SetPathValue("partition", test.partition)
+
+		w := httptest.NewRecorder()
+		res, err := h(struct{}{}, w, req)
+
+		if w.Code != test.status {
+			t.Errorf("expected status %d for %s/%s. Got %d (%v)", test.status, test.topic, test.partition, w.Code, err)
+		}
+
+		if test.status == http.StatusOK && (err != nil || res == nil) {
+			t.Errorf("expected response for %s/%s. Got %v", test.topic, test.partition, err)
+		}
+	}
+}
+
+// The routes predating topics produce to and consume from the default topic.
+func TestHandleDefault(t *testing.T) {
+	r, err := Open(t.TempDir(), log.Config{}, Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		r.Close()
+	})
+
+	if _, err := r.Create(DefaultTopic, Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests are made as the server would route them.
+	request := func(method, path string, values ...string) (*http.Request, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i < len(values); i += 2 {
+			req.SetPathValue(values[i], values[i+1])
+		}
+
+		return req, httptest.NewRecorder()
+	}
+
+	req, w := request(http.MethodPost, "/produce")
+	res, err := HandleDefault(r, produce.Produce)(produce.Request{Record: record.Record{Value: []byte("a")}}, w, req)
+	if err != nil || res.Offset != 0 {
+		t.Fatalf("expected offset %d. Got %v (%v)", 0, res, err)
+	}
+
+	req, w = request(http.MethodPost, "/produce/batch")
+	batch := produce.BatchRequest{Records: []record.Record{{Value: []byte("b")}, {Value: []byte("c")}}}
+	if res, err := HandleDefault(r, produce.ProduceBatch)(batch, w, req); err != nil || res.LastOffset != 2 {
+		t.Fatalf("expected last offset %d. Got %v (%v)", 2, res, err)
+	}
+
+	for off, value := range []string{"a", "b", "c"} {
+		req, w := request(http.MethodGet, "/consume", "offset", fmt.Sprint(off))
+
+		res, err := HandleDefault(r, consume.Consume)(consume.Request{}, w, req)
+		if err != nil || string(res.Record.Value) != value {
+			t.Errorf("expected value %q at offset %d. Got %v (%v)", value, off, res, err)
+		}
+	}
+
+	req, w = request(http.MethodGet, "/offsets/bounds")
+	if res, err := HandleDefault(r, offsets.Bounds)(offsets.Request{}, w, req); err != nil || res.Highest != 2 {
+		t.Errorf("expected highest offset %d. Got %v (%v)", 2, res, err)
+	}
+
+	// The default topic is the one produced to through its own routes.
+	topic, err := r.Acquire(DefaultTopic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topic.Release()
+
+	if l, _ := topic.Partition(0); l.HighestOffset() != 2 {
+		t.Errorf("expected highest offset %d. Got %d", 2, l.HighestOffset())
+	}
+}
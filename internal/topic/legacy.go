@@ -0,0 +1,95 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+)
+
+const (
+	// DefaultTopic is the topic into which the log kept directly in the data
+	// directory, before there were topics, is migrated.
+	DefaultTopic = "default"
+
+	// legacyDir is the directory under the data directory in which the topic
+	// migrated from such a log is prepared. Unlike those of [createPrefix], it
+	// holds records, so it is never removed.
+	legacyDir = ".legacy"
+
+	// cacheDir is the directory in which a log caches the tiered segments it
+	// fetches (see [log.Tiering]).
+	cacheDir = ".tier"
+)
+
+// legacyFile matches the names of the files of a log: its format file and the
+// store and indexes of each segment.
+var legacyFile = regexp.MustCompile(`^(format(\.tmp)?|[0-9]+\.(store|index|timeindex))$`)
+
+// migrateRoot migrates the log kept directly in the data directory before there
+// were topics into partition 0 of [DefaultTopic]. Its files are moved into
+// [legacyDir] along with the topic's configuration, and its tiered objects,
+// which are named without a prefix, are copied to the names of the partition.
+// The directory is then renamed to the topic's, so the topic appears with every
+// record at once.
+//
+// Every step may be repeated, so a migration interrupted by a crash is
+// completed when the registry is next opened. [ErrExists] is returned if there
+// is already a topic of the same name.
+func (r *Registry) migrateRoot() error {
+	files, err := os.ReadDir(r.dir)
+	if err != nil {
+		return err
+	}
+
+	var legacy []string
+	for _, file := range files {
+		name := file.Name()
+
+		if legacyFile.MatchString(name) && !file.IsDir() {
+			legacy = append(legacy, name)
+		}
+
+		if file.IsDir() && (name == cacheDir || strings.HasPrefix(name, segment.CleanPrefix)) {
+			legacy = append(legacy, name)
+		}
+	}
+
+	tmp := filepath.Join(r.dir, legacyDir)
+	if _, err := os.Stat(tmp); len(legacy) == 0 && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	dir := filepath.Join(r.dir, DefaultTopic)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, DefaultTopic)
+	}
+
+	part := filepath.Join(tmp, "0")
+	if err := os.MkdirAll(part, 0755); err != nil {
+		return err
+	}
+
+	for _, name := range legacy {
+		if err := os.Rename(filepath.Join(r.dir, name), filepath.Join(part, name)); err != nil {
+			return err
+		}
+	}
+
+	if err := writeConfig(tmp, Config{}.withDefaults()); err != nil {
+		return err
+	}
+
+	if r.base.Tiering.Store != nil {
+		err := migrateObjects(r.base.Tiering.Store, r.objects(partitionName(DefaultTopic, 0)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp, dir)
+}
@@ -0,0 +1,187 @@
+package topic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/tier"
+)
+
+// partitionName returns the name under which partition [p] of the topic [name]
+// keeps its tiered objects. Topic names do not contain '.', so the names of
+// different partitions never begin one another.
+func partitionName(name string, p int) string {
+	return fmt.Sprintf("%s.%d", name, p)
+}
+
+// Partition implements [storage.Partitioned]. The topic must be acquired.
+func (t *Topic) Partition(p int) (storage.Log, bool) {
+	if p < 0 || p >= len(t.partitions) {
+		return nil, false
+	}
+
+	return t.partitions[p], true
+}
+
+// PartitionFor implements [storage.Partitioned]. A key is routed by its 32-bit
+// FNV-1a hash modulo the number of partitions, while records without a key are
+// spread across the partitions in turn. The topic must be acquired.
+func (t *Topic) PartitionFor(key []byte) int {
+	n := uint32(len(t.partitions))
+
+	if len(key) == 0 {
+		return int((t.next.Add(1) - 1) % n)
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+
+	return int(h.Sum32() % n)
+}
+
+// IncreasePartitions increases the number of partitions of the topic [name] to
+// [n], once any users of it have released it. Existing partitions are left as
+// they are, so their records stay put while keys may be routed to different
+// partitions from then on. [ErrInvalidConfig] is returned if the topic already
+// has [n] or more partitions.
+//
+// The new partitions are opened before the topic's configuration is updated to
+// include them, so a crash part way through leaves the topic as it was.
+func (r *Registry) IncreasePartitions(name string, n int) (*Topic, error) {
+	r.mu.RLock()
+	t, ok := r.topics[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	c := t.Config()
+	if n <= c.Partitions {
+		return nil, fmt.Errorf("%w: topic %s already has %d partitions", ErrInvalidConfig, name, c.Partitions)
+	}
+
+	c.Partitions = n
+
+	partitions := t.partitions
+	for p := len(partitions); p < n; p++ {
+		l, err := r.openPartition(name, c, p)
+		if err == nil {
+			partitions = append(partitions, l)
+
+			continue
+		}
+
+		for _, l := range partitions[len(t.partitions):] {
+			l.Close()
+		}
+
+		return nil, fmt.Errorf("partition %d: %w", p, err)
+	}
+
+	if err := writeConfig(filepath.Join(r.dir, name), c); err != nil {
+		for _, l := range partitions[len(t.partitions):] {
+			l.Close()
+		}
+
+		return nil, err
+	}
+
+	t.partitions = partitions
+	t.config.Store(&c)
+
+	return t, nil
+}
+
+// migrate moves the log of the topic [name] into partition 0 if the topic was
+// created before topics were partitioned, when its log was kept in the topic's
+// directory itself. Its tiered objects are copied to the names of partition 0
+// before the originals are deleted. Every step may be repeated, so a migration
+// interrupted by a crash is completed when the registry is next opened.
+func (r *Registry) migrate(name string) error {
+	dir := filepath.Join(r.dir, name)
+	part := filepath.Join(dir, "0")
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), metaFile) {
+			continue
+		}
+
+		// The directories of partitions are named after their numbers, which
+		// are never the names of a log's files.
+		if _, err := strconv.Atoi(file.Name()); err == nil && file.IsDir() {
+			continue
+		}
+
+		if err := os.MkdirAll(part, 0755); err != nil {
+			return err
+		}
+
+		if err := os.Rename(filepath.Join(dir, file.Name()), filepath.Join(part, file.Name())); err != nil {
+			return err
+		}
+	}
+
+	if r.base.Tiering.Store == nil {
+		return nil
+	}
+
+	return migrateObjects(r.objects(name), r.objects(partitionName(name, 0)))
+}
+
+// migrateObjects copies the objects of an unpartitioned topic in [from] to
+// [to], and then deletes them. Such objects are named "<base><ext>", whereas
+// the names of a partition's objects in [from] begin with its number and a
+// '.'.
+func migrateObjects(from, to tier.ObjectStore) error {
+	names, err := from.List("")
+	if err != nil {
+		return err
+	}
+
+	var legacy []string
+	for _, name := range names {
+		if strings.Count(name, ".") == 1 {
+			legacy = append(legacy, name)
+		}
+	}
+
+	for _, name := range legacy {
+		obj, err := from.Get(name)
+		if err != nil {
+			return err
+		}
+
+		err = to.Put(name, obj)
+		obj.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range legacy {
+		if err := from.Delete(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,447 @@
+// package topic manages named topics, each kept in a subdirectory of the data
+// directory. A topic is split into partitions, each backed by its own commit
+// log in a subdirectory of the topic's named after its number. The topic's
+// directory also holds a metadata file recording its configuration, so topics
+// are rediscovered when the registry is reopened.
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/storage"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/tier"
+)
+
+const (
+	// createPrefix and deletePrefix begin the names of the temporary
+	// directories in which topics are created and deleted. Such a directory
+	// left behind by a crash can be removed.
+	createPrefix = ".create"
+	deletePrefix = ".delete"
+)
+
+var (
+	// ErrNotFound is returned when a topic does not exist.
+	ErrNotFound = errors.New("topic not found")
+
+	// ErrExists is returned when creating a topic which already exists.
+	ErrExists = errors.New("topic already exists")
+
+	// ErrInvalidName is returned when creating a topic whose name is not
+	// allowed (see [validName]).
+	ErrInvalidName = errors.New("invalid topic name")
+
+	// ErrInvalidConfig is returned when creating a topic with an invalid
+	// [Config].
+	ErrInvalidConfig = errors.New("invalid topic configuration")
+
+	// validName matches the names allowed for topics. Names double as
+	// directory names and as prefixes of tiered objects, so they are kept
+	// simple and may not contain '.'.
+	validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+)
+
+// Engine opens the log of a partition in [dir] with the configuration [c].
+type Engine func(dir string, c log.Config) (storage.Log, error)
+
+// Disk is an [Engine] backing partitions with a [log.Log].
+func Disk(dir string, c log.Config) (storage.Log, error) {
+	return log.New(dir, c)
+}
+
+// Memory is an [Engine] backing partitions with a [storage.Memory]. Their
+// records do not survive a restart, but the topics themselves do.
+func Memory(string, log.Config) (storage.Log, error) {
+	return storage.NewMemory(), nil
+}
+
+// Topic is a named set of partitions. It implements [storage.Partitioned].
+type Topic struct {
+	Name string
+
+	config atomic.Pointer[Config]
+
+	// mu is shared by users of the partitions and held exclusively to change
+	// them, so that a topic is never deleted or repartitioned from under a
+	// request.
+	mu         sync.RWMutex
+	closed     bool
+	partitions []storage.Log
+
+	// next is the partition to which the next record without a key is routed.
+	next atomic.Uint32
+}
+
+// Config returns the configuration specific to the topic.
+func (t *Topic) Config() Config {
+	return *t.config.Load()
+}
+
+// Release releases a topic returned by [Registry.Acquire].
+func (t *Topic) Release() {
+	t.mu.RUnlock()
+}
+
+// Registry is the set of topics kept in a data directory.
+type Registry struct {
+	dir    string
+	base   log.Config
+	engine Engine
+
+	mu     sync.RWMutex
+	topics map[string]*Topic
+}
+
+// Open opens the registry of topics kept in [dir], which is created if it does
+// not exist, and opens the partitions of every topic found there with [engine].
+// Each partition is configured with [base], overridden by the configuration of
+// its topic. If [base] tiers segments, partitions share its object store, and
+// the objects of each partition are named after it and its topic. A log kept
+// in [dir] itself is first migrated into [DefaultTopic].
+func Open(dir string, base log.Config, engine Engine) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	r := Registry{
+		dir:    dir,
+		base:   base,
+		engine: engine,
+		topics: make(map[string]*Topic),
+	}
+
+	if err := r.migrateRoot(); err != nil {
+		return nil, fmt.Errorf("topic %s: %w", DefaultTopic, err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !file.IsDir() {
+			continue
+		}
+
+		// A topic was being created or deleted when the registry was last open.
+		if strings.HasPrefix(name, createPrefix) || strings.HasPrefix(name, deletePrefix) {
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				r.Close()
+
+				return nil, err
+			}
+
+			continue
+		}
+
+		// Anything else in the directory is none of the registry's business.
+		if !validName.MatchString(name) {
+			continue
+		}
+
+		c, err := readConfig(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			r.Close()
+
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+
+		if err := r.migrate(name); err != nil {
+			r.Close()
+
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+
+		t, err := r.open(name, c)
+		if err != nil {
+			r.Close()
+
+			return nil, fmt.Errorf("topic %s: %w", name, err)
+		}
+
+		r.topics[name] = t
+	}
+
+	return &r, nil
+}
+
+// open opens the partitions of the topic [name] with the configuration [c].
+func (r *Registry) open(name string, c Config) (*Topic, error) {
+	t := Topic{Name: name}
+	t.config.Store(&c)
+
+	for p := range c.Partitions {
+		l, err := r.openPartition(name, c, p)
+		if err != nil {
+			t.close()
+
+			return nil, fmt.Errorf("partition %d: %w", p, err)
+		}
+
+		t.partitions = append(t.partitions, l)
+	}
+
+	return &t, nil
+}
+
+// openPartition opens the log of partition [p] of the topic [name] with the
+// configuration [c].
+func (r *Registry) openPartition(name string, c Config, p int) (storage.Log, error) {
+	lc := c.apply(r.base)
+	if lc.Tiering.Store != nil {
+		lc.Tiering.Store = r.objects(partitionName(name, p))
+	}
+
+	return r.engine(filepath.Join(r.dir, name, strconv.Itoa(p)), lc)
+}
+
+// objects returns the object store holding the tiered segments named after
+// [name], which is either a topic or one of its partitions.
+func (r *Registry) objects(name string) tier.ObjectStore {
+	return tier.Prefix(r.base.Tiering.Store, name+".")
+}
+
+// Create creates the topic [name] with the configuration [c]. [ErrExists] is
+// returned if there is already such a topic.
+//
+// The topic's directory is prepared under a temporary name and then renamed
+// into place, so a crash never leaves a topic without its configuration.
+func (r *Registry) Create(name string, c Config) (*Topic, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	c = c.withDefaults()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.topics[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrExists, name)
+	}
+
+	tmp, err := os.MkdirTemp(r.dir, createPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := writeConfig(tmp, c); err != nil {
+		return nil, err
+	}
+
+	// The rename fails if a directory of the same name already holds files.
+	if err := os.Rename(tmp, filepath.Join(r.dir, name)); err != nil {
+		return nil, err
+	}
+
+	t, err := r.open(name, c)
+	if err != nil {
+		os.RemoveAll(filepath.Join(r.dir, name))
+
+		return nil, err
+	}
+
+	r.topics[name] = t
+
+	return t, nil
+}
+
+// Acquire returns the topic [name], or [ErrNotFound] if there is none. The
+// topic is not deleted until the caller releases it with [Topic.Release].
+func (r *Registry) Acquire(name string) (*Topic, error) {
+	r.mu.RLock()
+	t, ok := r.topics[name]
+	r.mu.RUnlock()
+
+	if !ok || !t.acquire() {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	return t, nil
+}
+
+// acquire acquires the topic for [Registry.Acquire], reporting whether it has
+// not been closed in the meantime.
+func (t *Topic) acquire() bool {
+	t.mu.RLock()
+	if t.closed {
+		t.mu.RUnlock()
+
+		return false
+	}
+
+	return true
+}
+
+// Topics returns every topic, sorted by name.
+func (r *Registry) Topics() []*Topic {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	topics := make([]*Topic, 0, len(r.topics))
+	for _, t := range r.topics {
+		topics = append(topics, t)
+	}
+
+	slices.SortFunc(topics, func(a, b *Topic) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return topics
+}
+
+// Delete deletes the topic [name] along with its partitions, once any users of
+// it have released it. [ErrNotFound] is returned if there is no such topic.
+//
+// The topic's directory is renamed before it is removed, so a crash part way
+// through never leaves a topic with only some of its records.
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+	t, ok := r.topics[name]
+	delete(r.topics, name)
+	r.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	if err := t.close(); err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp(r.dir, deletePrefix)
+	if err != nil {
+		return err
+	}
+
+	// The topic's directory is moved into the temporary one, which is then
+	// removed along with it.
+	if err := os.Rename(filepath.Join(r.dir, name), filepath.Join(tmp, name)); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	if r.base.Tiering.Store == nil {
+		return nil
+	}
+
+	// The objects of every partition are named after the topic.
+	objects := r.objects(name)
+
+	names, err := objects.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		if err := objects.Delete(n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// close closes the partitions of the topic once any users of it have released
+// it. Every partition is closed even if some fail to, and the first error is
+// returned.
+func (t *Topic) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+
+	var err error
+	for _, l := range t.partitions {
+		if cerr := l.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Reencrypt implements [storage.Reencrypter], reencrypting every partition
+// which supports it.
+func (r *Registry) Reencrypt() (int, error) {
+	var total int
+	for _, t := range r.Topics() {
+		if !t.acquire() {
+			continue
+		}
+
+		n, err := t.reencrypt()
+		t.Release()
+
+		total += n
+
+		if err != nil {
+			return total, fmt.Errorf("topic %s: %w", t.Name, err)
+		}
+	}
+
+	return total, nil
+}
+
+// reencrypt reencrypts every partition of the acquired topic which supports
+// it.
+func (t *Topic) reencrypt() (int, error) {
+	var total int
+	for p, l := range t.partitions {
+		e, ok := l.(storage.Reencrypter)
+		if !ok {
+			continue
+		}
+
+		n, err := e.Reencrypt()
+		total += n
+
+		if err != nil {
+			return total, fmt.Errorf("partition %d: %w", p, err)
+		}
+	}
+
+	return total, nil
+}
+
+// Close closes the partitions of every topic.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.topics {
+		if err := t.close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,420 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/record"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/tier"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := t.TempDir()
+
+	objects, err := tier.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := log.Config{
+		Segment: segment.Config{MaxIndexBytes: 1 << 10},
+		Tiering: log.Tiering{Store: objects},
+	}
+
+	r, err := Open(dir, base, Disk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Segments of "orders" hold 3 records.
+	c := Config{Partitions: 1, MaxIndexBytes: 12 * 3, RetentionBytes: 1 << 20}
+	if _, err := r.Create("orders", c); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Create("payments", Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Create("orders", Config{}); !errors.Is(err, ErrExists) {
+		t.Errorf("expected %v. Got %v", ErrExists, err)
+	}
+
+	for _, name := range []string{"", ".hidden", "a.b", "../orders", "a/b"} {
+		if _, err := r.Create(name, Config{}); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("expected %v creating %q. Got %v", ErrInvalidName, name, err)
+		}
+	}
+
+	if _, err := r.Create("invalid", Config{RetentionMs: -1}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected %v. Got %v", ErrInvalidConfig, err)
+	}
+
+	// Topics have independent logs.
+	topic, err := r.Acquire("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders, _ := topic.Partition(0)
+
+	for i := 0; i < 4; i++ {
+		if _, err := orders.Append(&record.Record{Value: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	topic.Release()
+
+	if _, err := os.Stat(filepath.Join(dir, "orders", "0", "3.store")); err != nil {
+		t.Errorf("expected the topic's configuration to roll its segments: %v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leftovers of an interrupted creation are removed, and anything else is
+	// ignored.
+	for _, name := range []string{createPrefix + "123", "notes"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r, err = Open(dir, base, Disk); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		r.Close()
+	})
+
+	if _, err := os.Stat(filepath.Join(dir, createPrefix+"123")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected leftover directory to be removed. Got %v", err)
+	}
+
+	var names []string
+	for _, topic := range r.Topics() {
+		names = append(names, topic.Name)
+	}
+
+	if fmt.Sprint(names) != "[orders payments]" {
+		t.Fatalf("expected topics [orders payments]. Got %v", names)
+	}
+
+	if topic := r.Topics()[0]; topic.Config() != c {
+		t.Errorf("expected configuration %+v. Got %+v", c, topic.Config())
+	}
+
+	if topic, err = r.Acquire("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, _ = topic.Partition(0)
+
+	if high := orders.HighestOffset(); high != 3 {
+		t.Errorf("expected highest offset of %d. Got %d", 3, high)
+	}
+
+	topic.Release()
+
+	// Deleting a topic removes its files along with its tiered objects.
+	if err := objects.Put("orders.0.store", strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Delete("orders"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Acquire("orders"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v. Got %v", ErrNotFound, err)
+	}
+
+	if err := r.Delete("orders"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected %v. Got %v", ErrNotFound, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "orders")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected topic directory to be removed. Got %v", err)
+	}
+
+	if names, _ := objects.List("orders."); len(names) != 0 {
+		t.Errorf("expected tiered objects to be removed. Got %v", names)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	t.Run("Routing", func(t *testing.T) {
+		r, err := Open(t.TempDir(), log.Config{}, Memory)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			r.Close()
+		})
+
+		if _, err := r.Create("orders", Config{Partitions: 4}); err != nil {
+			t.Fatal(err)
+		}
+
+		topic, err := r.Acquire("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer topic.Release()
+
+		for _, key := range []string{"a", "b", "c", "d"} {
+			p := topic.PartitionFor([]byte(key))
+			if p < 0 || p >= 4 {
+				t.Fatalf("expected a partition in [0, 4). Got %d", p)
+			}
+
+			if again := topic.PartitionFor([]byte(key)); again != p {
+				t.Errorf("expected key %q to be routed to %d. Got %d", key, p, again)
+			}
+		}
+
+		// Records without a key are spread across every partition.
+		seen := make(map[int]bool)
+		for i := 0; i < 4; i++ {
+			seen[topic.PartitionFor(nil)] = true
+		}
+
+		if len(seen) != 4 {
+			t.Errorf("expected records without a key to use %d partitions. Got %d", 4, len(seen))
+		}
+
+		if _, ok := topic.Partition(4); ok {
+			t.Errorf("expected no partition %d", 4)
+		}
+	})
+
+	t.Run("Increase", func(t *testing.T) {
+		dir := t.TempDir()
+
+		r, err := Open(dir, log.Config{}, Disk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.Create("orders", Config{Partitions: 2}); err != nil {
+			t.Fatal(err)
+		}
+
+		topic, err := r.Acquire("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for p := 0; p < 2; p++ {
+			l, _ := topic.Partition(p)
+			if _, err := l.Append(&record.Record{Value: []byte(fmt.Sprint(p))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		topic.Release()
+
+		if _, err := r.IncreasePartitions("orders", 2); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("expected %v. Got %v", ErrInvalidConfig, err)
+		}
+
+		if _, err := r.IncreasePartitions("payments", 3); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v. Got %v", ErrNotFound, err)
+		}
+
+		if _, err := r.IncreasePartitions("orders", 3); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if r, err = Open(dir, log.Config{}, Disk); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			r.Close()
+		})
+
+		if topic, err = r.Acquire("orders"); err != nil {
+			t.Fatal(err)
+		}
+		defer topic.Release()
+
+		if n := topic.Config().Partitions; n != 3 {
+			t.Fatalf("expected %d partitions. Got %d", 3, n)
+		}
+
+		// Existing partitions keep their records.
+		for p := 0; p < 2; p++ {
+			l, _ := topic.Partition(p)
+
+			rec, err := l.Read(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(rec.Value) != fmt.Sprint(p) {
+				t.Errorf("expected partition %d to hold %d. Got %s", p, p, rec.Value)
+			}
+		}
+
+		if l, _ := topic.Partition(2); l.HighestOffset() != 0 {
+			t.Errorf("expected new partition to be empty")
+		}
+	})
+
+	// A topic created before topics were partitioned keeps its log in its own
+	// directory, and its tiered objects under its name.
+	t.Run("Migration", func(t *testing.T) {
+		dir := t.TempDir()
+
+		objects, err := tier.NewDir(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		base := log.Config{Tiering: log.Tiering{Store: objects}}
+
+		l, err := log.New(filepath.Join(dir, "orders"), log.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := l.Append(&record.Record{Value: []byte("legacy")}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writeConfig(filepath.Join(dir, "orders"), Config{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := objects.Put("orders.0.store", strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := Open(dir, base, Disk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			r.Close()
+		})
+
+		topic, err := r.Acquire("orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer topic.Release()
+
+		if n := topic.Config().Partitions; n != 1 {
+			t.Fatalf("expected %d partition. Got %d", 1, n)
+		}
+
+		orders, _ := topic.Partition(0)
+
+		rec, err := orders.Read(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(rec.Value) != "legacy" {
+			t.Errorf("expected record %q. Got %q", "legacy", rec.Value)
+		}
+
+		if names, _ := objects.List(""); fmt.Sprint(names) != "[orders.0.0.store]" {
+			t.Errorf("expected tiered objects [orders.0.0.store]. Got %v", names)
+		}
+	})
+
+	// The log kept in the data directory before there were topics becomes the
+	// default topic, and its tiered objects are named after it.
+	t.Run("MigrationRoot", func(t *testing.T) {
+		dir := t.TempDir()
+
+		objects, err := tier.NewDir(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		base := log.Config{Tiering: log.Tiering{Store: objects}}
+
+		l, err := log.New(dir, log.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := l.Append(&record.Record{Value: []byte("legacy")}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := objects.Put("0.store", strings.NewReader("")); err != nil {
+			t.Fatal(err)
+		}
+
+		// A migration interrupted after the format file was moved is
+		// completed.
+		if err := os.MkdirAll(filepath.Join(dir, legacyDir, "0"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Rename(filepath.Join(dir, "format"), filepath.Join(dir, legacyDir, "0", "format")); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := Open(dir, base, Disk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			r.Close()
+		})
+
+		topic, err := r.Acquire(DefaultTopic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer topic.Release()
+
+		l0, _ := topic.Partition(0)
+
+		rec, err := l0.Read(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(rec.Value) != "legacy" {
+			t.Errorf("expected record %q. Got %q", "legacy", rec.Value)
+		}
+
+		if names, _ := objects.List(""); fmt.Sprint(names) != "[default.0.0.store]" {
+			t.Errorf("expected tiered objects [default.0.0.store]. Got %v", names)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, legacyDir)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed. Got %v", legacyDir, err)
+		}
+	})
+}
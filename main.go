@@ -4,15 +4,209 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/beautifultovarisch/dlog/internal/group"
 	"github.com/beautifultovarisch/dlog/internal/server"
+	"github.com/beautifultovarisch/dlog/internal/topic"
 
+	"github.com/beautifultovarisch/dlog/internal/api/admin"
 	"github.com/beautifultovarisch/dlog/internal/api/consume"
+	"github.com/beautifultovarisch/dlog/internal/api/groups"
+	"github.com/beautifultovarisch/dlog/internal/api/offsets"
 	"github.com/beautifultovarisch/dlog/internal/api/produce"
+
+	"github.com/beautifultovarisch/dlog/internal/commitlog/keyring"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/log"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/segment"
+	"github.com/beautifultovarisch/dlog/internal/commitlog/tier"
 )
 
+// codecs maps the names accepted by the -codec flag to segment codecs.
+var codecs = map[string]segment.Codec{
+	"none":  segment.CodecNone,
+	"gzip":  segment.CodecGzip,
+	"flate": segment.CodecFlate,
+}
+
 func main() {
-	server.Route("GET /consume/{offset}", consume.Consume)
-	server.Route("POST /produce", produce.Produce)
+	engine := flag.String("engine", "disk", "storage engine backing the commit log: disk or memory")
+	dir := flag.String("dir", "data", "directory in which each topic's commit log is persisted")
+	codec := flag.String("codec", "none", "compression applied to record batches: none, gzip or flate")
+	indexInterval := flag.Uint64("index-interval", 0, "store bytes between sparse index entries (0 indexes every record)")
+	segmentAge := flag.Duration("segment-age", 0, "age after which the active segment is rolled (0 only rolls full segments)")
+	maxAge := flag.Duration("retention-age", 0, "age after which sealed segments are deleted (0 keeps them forever)")
+	maxBytes := flag.Uint64("retention-bytes", 0, "size of the log beyond which sealed segments are deleted (0 is unbounded)")
+	compact := flag.Bool("compact", false, "keep only the newest record for each key in sealed segments")
+	tombstones := flag.Duration("tombstone-retention", 24*time.Hour, "age after which compaction removes tombstones")
+	keyFile := flag.String("keyring", "", "file holding the keys with which segments are encrypted (empty disables encryption)")
+	tierDir := flag.String("tier-dir", "", "directory to which sealed segments are offloaded (empty keeps them on local disk)")
+	hotSegments := flag.Int("hot-segments", 0, "number of the newest sealed segments kept on local disk when offloading")
+	flag.Parse()
+
+	c, ok := codecs[*codec]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown codec: %s\n", *codec)
+		os.Exit(2)
+	}
+
+	segments := segment.Config{Codec: c, IndexInterval: *indexInterval, MaxSegmentAge: *segmentAge}
+
+	var keys *keyring.File
+	if *keyFile != "" {
+		var err error
+		if keys, err = keyring.OpenFile(*keyFile); err != nil {
+			panic(err)
+		}
+
+		segments.Keys = keys
+	}
+
+	tiering := log.Tiering{HotSegments: *hotSegments}
+	if *tierDir != "" {
+		store, err := tier.NewDir(*tierDir)
+		if err != nil {
+			panic(err)
+		}
+
+		tiering.Store = store
+	}
+
+	var open topic.Engine
+	switch *engine {
+	case "disk":
+		open = topic.Disk
+	case "memory":
+		open = topic.Memory
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine: %s\n", *engine)
+		os.Exit(2)
+	}
+
+	// Every topic shares this configuration unless its own overrides it.
+	topics, err := topic.Open(*dir, log.Config{
+		Segment: segments,
+		Retention: log.Retention{
+			MaxAge:   *maxAge,
+			MaxBytes: *maxBytes,
+		},
+		Compaction: log.Compaction{
+			Enabled:            *compact,
+			TombstoneRetention: *tombstones,
+		},
+		Tiering: tiering,
+	}, open)
+	if err != nil {
+		panic(err)
+	}
+
+	// The routes predating topics need the default topic to serve, even if
+	// there was no log to migrate into it.
+	if _, err := topics.Create(topic.DefaultTopic, topic.Config{}); err != nil && !errors.Is(err, topic.ErrExists) {
+		panic(err)
+	}
+
+	for _, t := range topics.Topics() {
+		for p := range t.Config().Partitions {
+			l, _ := t.Partition(p)
+
+			disk, ok := l.(*log.Log)
+			if !ok {
+				continue
+			}
+
+			if r := disk.Recovery; r != (segment.Recovery{}) {
+				fmt.Fprintf(os.Stderr, "recovered topic %s partition %d: discarded %d index entries and %d store bytes, rebuilt %d index entries\n", t.Name, p, r.Records, r.Bytes, r.Reindexed)
+			}
+
+			for _, d := range disk.Damaged {
+				fmt.Fprintf(os.Stderr, "damaged topic %s partition %d: %v at position %d, truncated\n", t.Name, p, d, d.Pos)
+			}
+		}
+	}
+
+	// Committed offsets are never subject to retention, and compaction keeps
+	// only the newest offset of each group and partition.
+	offsetLog, err := open(filepath.Join(*dir, group.Dir), log.Config{
+		Segment: segments,
+		Compaction: log.Compaction{
+			Enabled:            true,
+			TombstoneRetention: *tombstones,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	consumers, err := group.Open(offsetLog)
+	if err != nil {
+		panic(err)
+	}
+
+	// Offsets may outlive their topic if it was deleted while they were being
+	// committed.
+	err = consumers.Prune(func(name string) bool {
+		t, err := topics.Acquire(name)
+		if err != nil {
+			return false
+		}
+
+		t.Release()
+
+		return true
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	server.Route("GET /topics/{topic}/partitions/{partition}/consume", topic.Handle(topics, consume.Resume(consumers)))
+	server.Route("GET /topics/{topic}/partitions/{partition}/consume/{offset}", topic.Handle(topics, consume.Consume))
+	server.Route("GET /topics/{topic}/partitions/{partition}/offsets", topic.Handle(topics, offsets.Offsets))
+	server.Route("GET /topics/{topic}/partitions/{partition}/offsets/bounds", topic.Handle(topics, offsets.Bounds))
+	// Routes predating partitions are served by partition 0 of topics with a
+	// single partition.
+	server.Route("GET /topics/{topic}/consume", topic.Handle(topics, consume.Resume(consumers)))
+	server.Route("GET /topics/{topic}/consume/{offset}", topic.Handle(topics, consume.Consume))
+	server.Route("GET /topics/{topic}/offsets", topic.Handle(topics, offsets.Offsets))
+	server.Route("GET /topics/{topic}/offsets/bounds", topic.Handle(topics, offsets.Bounds))
+	server.Route("POST /topics/{topic}/produce", topic.Handle(topics, produce.Produce))
+	server.Route("POST /topics/{topic}/produce/batch", topic.Handle(topics, produce.ProduceBatch))
+
+	// Routes predating topics are served by the default topic, into which the
+	// log they served is migrated.
+	server.Route("GET /consume/{offset}", topic.HandleDefault(topics, consume.Consume))
+	server.Route("GET /offsets", topic.HandleDefault(topics, offsets.Offsets))
+	server.Route("GET /offsets/bounds", topic.HandleDefault(topics, offsets.Bounds))
+	server.Route("POST /produce", topic.HandleDefault(topics, produce.Produce))
+	server.Route("POST /produce/batch", topic.HandleDefault(topics, produce.ProduceBatch))
+
+	server.Route("POST /groups/{group}/commit", groups.Commit(consumers, topics))
+	server.Route("GET /groups/{group}/offsets", groups.Offsets(consumers))
+
+	server.Route("GET /admin/topics", admin.ListTopics(topics))
+	server.Route("POST /admin/topics", admin.CreateTopic(topics))
+	server.Route("POST /admin/topics/{topic}/partitions", admin.IncreasePartitions(topics))
+	server.Route("DELETE /admin/topics/{topic}", admin.DeleteTopic(topics, consumers))
+	server.Route("POST /admin/reencrypt", admin.Reencrypt(topics, consumers))
+
+	if keys != nil {
+		server.Route("POST /admin/keys/rotate", admin.Rotate(keys))
+	}
 
 	server.Run()
+
+	// The server has drained all connections at this point, so no handler can
+	// still be using a topic.
+	if err := topics.Close(); err != nil {
+		panic(err)
+	}
+
+	if err := consumers.Close(); err != nil {
+		panic(err)
+	}
 }